@@ -0,0 +1,403 @@
+package bigfft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"time"
+)
+
+// CalibrateOptions controls the measurements done by Calibrate.
+// A zero field selects the default value of that option.
+type CalibrateOptions struct {
+	// MinTime is the minimal duration of each measurement.
+	// The default is 100ms.
+	MinTime time.Duration
+
+	// MaxK is the largest FFT length 1<<MaxK to calibrate.
+	// The default is 12. Calibrating large lengths is slow,
+	// and larger lengths keep their default thresholds.
+	MaxK uint
+
+	// Log, if not nil, receives the detailed measurements.
+	Log io.Writer
+}
+
+// calibrateMaxK is the largest FFT length for which
+// search bounds are known.
+const calibrateMaxK = 16
+
+// Calibrate measures multiplication on the current machine and
+// returns a Config tuned for it. It compares FFT lengths to
+// tune the SizeThresholds table, compares FFT to math/big to tune
+// Threshold, compares negacyclic products to math/big to tune
+// RecursionThreshold, compares four-step transforms to recursive ones
+// to tune FourStepThreshold, compares number-theoretic transforms to
+// FFT to tune NTTThreshold, then compares floating-point FFT to
+// math/big to tune FloatThreshold. Other fields have their default
+// values.
+//
+// Calibration takes from seconds to several minutes depending on
+// options. Its result can be stored as JSON to be reused later.
+func Calibrate(opts CalibrateOptions) (Config, error) {
+	if opts.MinTime == 0 {
+		opts.MinTime = 100 * time.Millisecond
+	}
+	if opts.MaxK == 0 {
+		opts.MaxK = 12
+	}
+	if opts.MaxK > calibrateMaxK {
+		return Config{}, fmt.Errorf("bigfft: cannot calibrate FFT lengths above 1<<%d", calibrateMaxK)
+	}
+	if opts.Log == nil {
+		opts.Log = ioutil.Discard
+	}
+	cal := calibrator{opts: opts, rnd: rand.New(rand.NewSource(1))}
+
+	c := DefaultConfig()
+	if err := cal.fftSizes(&c); err != nil {
+		return Config{}, err
+	}
+	if err := cal.threshold(&c); err != nil {
+		return Config{}, err
+	}
+	if err := cal.recursionThreshold(&c); err != nil {
+		return Config{}, err
+	}
+	cal.fourStepThreshold(&c)
+	if err := cal.nttThreshold(&c); err != nil {
+		return Config{}, err
+	}
+	if err := cal.floatThreshold(&c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+type calibrator struct {
+	opts CalibrateOptions
+	rnd  *rand.Rand
+}
+
+func (cal *calibrator) logf(format string, args ...interface{}) {
+	fmt.Fprintf(cal.opts.Log, format, args...)
+}
+
+func (cal *calibrator) rndNat(n int) nat {
+	x := make(nat, n)
+	for i := range x {
+		x[i] = big.Word(cal.rnd.Int63()<<1 + cal.rnd.Int63n(2))
+	}
+	return x
+}
+
+// measure returns the average duration of f.
+func (cal *calibrator) measure(f func()) time.Duration {
+	for n := 1; ; n *= 2 {
+		t0 := time.Now()
+		for i := 0; i < n; i++ {
+			f()
+		}
+		if d := time.Since(t0); d >= cal.opts.MinTime {
+			return d / time.Duration(n)
+		}
+	}
+}
+
+// threshold tunes c.Threshold, the size above which FFT is used.
+func (cal *calibrator) threshold(c *Config) error {
+	lower := int(1e3)   // math/big is faster at this size.
+	upper := int(300e3) // FFT is faster at this size.
+	speedup := func(bits int) float64 {
+		x := cal.rndNat(bits / _W)
+		y := cal.rndNat(bits / _W)
+		var xi, yi, zi big.Int
+		xi.SetBits(x)
+		yi.SetBits(y)
+		e := &env{cfg: c}
+		tBig := cal.measure(func() { zi.Mul(&xi, &yi) })
+		tFFT := cal.measure(func() { fftmulTo(e, nil, x, y) })
+		spd := float64(tBig) / float64(tFFT)
+		cal.logf("speedup of FFT over math/big at size %d bits: %.2f (%s vs %s)\n",
+			bits, spd, roundDur(tBig), roundDur(tFFT))
+		return spd
+	}
+	// fftmulTo must not use floating-point FFT while measuring.
+	c.FloatThreshold = -1
+	bits, err := cal.crossover(lower, upper, speedup)
+	if err != nil {
+		return err
+	}
+	c.Threshold = bits / _W
+	cal.logf("threshold: %d words\n", c.Threshold)
+	return nil
+}
+
+// recursionThreshold tunes c.RecursionThreshold, the size of values
+// above which pointwise products use negacyclic convolution.
+func (cal *calibrator) recursionThreshold(c *Config) error {
+	lower := 256   // math/big is faster at this size.
+	upper := 16384 // negacyclic products are faster at this size.
+	speedup := func(n int) float64 {
+		// Values must be cut in chunks by negacyclicSize.
+		n = (n + 63) &^ 63
+		x := make(fermat, n+1)
+		y := make(fermat, n+1)
+		copy(x, cal.rndNat(n))
+		copy(y, cal.rndNat(n))
+		z := make(fermat, n+1)
+		buf := make(fermat, 8*n)
+		e := &env{cfg: c}
+		tBig := cal.measure(func() { copy(z, buf.Mul(x, y)) })
+		tNeg := cal.measure(func() { e.mulNegacyclic(z, x, y) })
+		spd := float64(tBig) / float64(tNeg)
+		cal.logf("speedup of negacyclic product over math/big at size %d words: %.2f (%s vs %s)\n",
+			n, spd, roundDur(tBig), roundDur(tNeg))
+		return spd
+	}
+	// Chunks of negacyclic products must not recurse while measuring.
+	c.RecursionThreshold = -1
+	n, err := cal.crossover(lower, upper, speedup)
+	if err != nil {
+		return err
+	}
+	c.RecursionThreshold = n
+	cal.logf("recursion threshold: %d words\n", c.RecursionThreshold)
+	return nil
+}
+
+// fourStepThreshold tunes c.FourStepThreshold, the size of vectors
+// above which Fourier transforms are computed in four steps. Vectors
+// of 1<<MaxK values, but at most 1<<12, are transformed for values of
+// increasing size.
+func (cal *calibrator) fourStepThreshold(c *Config) {
+	k := cal.opts.MaxK
+	if k > 12 {
+		// Larger vectors of the largest values use hundreds of MB.
+		k = 12
+	}
+	speedup := func(n int) float64 {
+		src := make([]fermat, 1<<k)
+		dst := make([]fermat, 1<<k)
+		for i := range src {
+			src[i] = make(fermat, n+1)
+			dst[i] = make(fermat, n+1)
+			copy(src[i], cal.rndNat(n))
+		}
+		e := &env{cfg: c}
+		tRec := cal.measure(func() { e.fourier(dst, src, false, n, k) })
+		tFour := cal.measure(func() { e.fourierFourStep(dst, src, false, n, k) })
+		spd := float64(tRec) / float64(tFour)
+		cal.logf("speedup of four-step transform over recursive at size %d words, length %d: %.2f (%s vs %s)\n",
+			n, 1<<k, spd, roundDur(tRec), roundDur(tFour))
+		return spd
+	}
+	// e.fourier must not use four-step transforms while measuring.
+	c.FourStepThreshold = -1
+	threshold := -1
+	// For k <= 12, n*_W is a multiple of 1<<(k-1) as required.
+	for n := 64; n <= 1024; n *= 2 {
+		switch spd := speedup(n); {
+		case spd < 1.02:
+			// Four-step transforms must be faster for all larger sizes.
+			threshold = -1
+		case threshold < 0:
+			threshold = (n + 1) << k
+		}
+	}
+	if threshold < 0 {
+		cal.logf("four-step transforms are not faster than recursive ones\n")
+		return
+	}
+	c.FourStepThreshold = threshold
+	cal.logf("four-step threshold: %d words\n", c.FourStepThreshold)
+}
+
+// nttThreshold tunes c.NTTThreshold, the size of products
+// up to which number-theoretic transforms are used.
+func (cal *calibrator) nttThreshold(c *Config) error {
+	lower := 2 * c.Threshold // the smallest products using FFT.
+	upper := int(400e3)
+	speedup := func(words int) float64 {
+		x := cal.rndNat(words / 2)
+		y := cal.rndNat(words / 2)
+		e := &env{cfg: c}
+		tNTT := cal.measure(func() { nttmulTo(e, nil, x, y) })
+		tFFT := cal.measure(func() { fftmulTo(e, nil, x, y) })
+		spd := float64(tNTT) / float64(tFFT)
+		cal.logf("speedup of FFT over NTT at size %d words: %.2f (%s vs %s)\n",
+			words, spd, roundDur(tNTT), roundDur(tFFT))
+		return spd
+	}
+	// fftmulTo must not use NTT while measuring.
+	c.NTTThreshold = -1
+	switch {
+	case speedup(lower) > 1:
+		cal.logf("NTT is slower than FFT\n")
+		return nil
+	case speedup(upper) < 1:
+		c.NTTThreshold = upper
+	default:
+		words, err := cal.crossover(lower, upper, speedup)
+		if err != nil {
+			return err
+		}
+		c.NTTThreshold = words
+	}
+	cal.logf("NTT threshold: %d words\n", c.NTTThreshold)
+	return nil
+}
+
+// floatThreshold tunes c.FloatThreshold, the size above which
+// floating-point FFT is used below c.Threshold.
+func (cal *calibrator) floatThreshold(c *Config) error {
+	lower := 20
+	// Products of 1<<floatMaxK limbs at most.
+	upper := 1<<floatMaxK/floatLimbs/2 - 1
+	if upper > c.Threshold {
+		upper = c.Threshold
+	}
+	speedup := func(words int) float64 {
+		x := cal.rndNat(words)
+		y := cal.rndNat(words)
+		var xi, yi, zi big.Int
+		xi.SetBits(x)
+		yi.SetBits(y)
+		e := &env{cfg: c}
+		tBig := cal.measure(func() { zi.Mul(&xi, &yi) })
+		tFloat := cal.measure(func() { floatmulTo(e, nil, x, y) })
+		spd := float64(tBig) / float64(tFloat)
+		cal.logf("speedup of float FFT over math/big at size %d words: %.2f (%s vs %s)\n",
+			words, spd, roundDur(tBig), roundDur(tFloat))
+		return spd
+	}
+	c.FloatThreshold = -1
+	switch {
+	case lower >= upper || speedup(upper) < 1:
+		cal.logf("float FFT is slower than math/big\n")
+		return nil
+	case speedup(lower) > 1:
+		c.FloatThreshold = lower
+	default:
+		words, err := cal.crossover(lower, upper, speedup)
+		if err != nil {
+			return err
+		}
+		c.FloatThreshold = words
+	}
+	cal.logf("float threshold: %d words\n", c.FloatThreshold)
+	return nil
+}
+
+// fftSizes tunes c.SizeThresholds for FFT lengths up to 1<<MaxK.
+func (cal *calibrator) fftSizes(c *Config) error {
+	// FFT of size 1<<k is known to be faster than 2<<k for
+	// operands of lows[k] words, and slower for his[k] words.
+	lows := [...]int{10, 10, 10, 10,
+		20, 50, 100, 200, 500, // 8
+		1000, 2000, 5000, 10000, // 12
+		20000, 50000, 100e3, 200e3, // 16
+	}
+	his := [...]int{100, 100, 100, 200,
+		500, 1000, 2000, 5000, 10000, // 8
+		50e3, 100e3, 200e3, 800e3, // 12
+		2e6, 5e6, 10e6, 20e6, // 16
+	}
+	for len(c.SizeThresholds) <= int(cal.opts.MaxK) {
+		last := c.SizeThresholds[len(c.SizeThresholds)-1]
+		c.SizeThresholds = append(c.SizeThresholds, 2*last)
+	}
+	for k := uint(3); k <= cal.opts.MaxK; k++ {
+		// Measure the speedup between k and k+1
+		speedup := func(words int) float64 {
+			t1, t2 := cal.measureFFTSize(words, k), cal.measureFFTSize(words, k+1)
+			spd := float64(t1) / float64(t2)
+			cal.logf("speedup of %d vs %d at size %d words: %.2f (%s vs %s)\n",
+				k+1, k, words, spd, roundDur(t1), roundDur(t2))
+			return spd
+		}
+		words, err := cal.crossover(lows[k], his[k], speedup)
+		if err != nil {
+			return err
+		}
+		// The product of operands of the given size
+		// is the largest one using length 1<<k.
+		c.SizeThresholds[k] = 2 * int64(words) * int64(_W)
+		cal.logf("FFT length %d is used up to %d bits\n", 1<<k, c.SizeThresholds[k])
+	}
+	// Keep the table sorted.
+	for k := 1; k < len(c.SizeThresholds); k++ {
+		if c.SizeThresholds[k] < c.SizeThresholds[k-1] {
+			c.SizeThresholds[k] = c.SizeThresholds[k-1]
+		}
+	}
+	return nil
+}
+
+// measureFFTSize measures the FFT multiplication of operands
+// of w words, using a FFT of length 1<<k.
+func (cal *calibrator) measureFFTSize(w int, k uint) time.Duration {
+	x := cal.rndNat(w)
+	y := cal.rndNat(w)
+	return cal.measure(func() {
+		m := (w+w)>>k + 1
+		xp := polyFromNat(x, k, m)
+		yp := polyFromNat(y, k, m)
+		rp := xp.Mul(&yp)
+		_ = rp.Int()
+	})
+}
+
+var errCalibrate = errors.New("bigfft: inconsistent measurements during calibration")
+
+// crossover searches the size between lower and upper where
+// speedup crosses 1. It is assumed that speedup is below 1 at
+// lower and above 1 at upper.
+func (cal *calibrator) crossover(lower, upper int, speedup func(size int) float64) (int, error) {
+	var sizes [9]int
+	var speedups [9]float64
+	for i := 0; i < 3; i++ {
+		for idx := 1; idx <= 9; idx++ {
+			sz := ((10-idx)*lower + idx*upper) / 10
+			sizes[idx-1] = sz
+			speedups[idx-1] = speedup(sz)
+		}
+		narrow := false
+		for idx, s := range speedups {
+			if s < .98 {
+				lower = sizes[idx]
+				narrow = true
+			} else {
+				break
+			}
+		}
+		for idx := range speedups {
+			if speedups[8-idx] > 1.02 {
+				upper = sizes[8-idx]
+				narrow = true
+			} else {
+				break
+			}
+		}
+		if lower >= upper {
+			return 0, errCalibrate
+		}
+		if !narrow || (upper-lower) <= 10 {
+			break
+		}
+	}
+	cal.logf("sizes: %d\n", sizes)
+	cal.logf("speedups: %.2f\n", speedups)
+	return (lower + upper) / 2, nil
+}
+
+func roundDur(d time.Duration) time.Duration {
+	if d > 100*time.Millisecond {
+		return d / time.Millisecond * time.Millisecond
+	} else {
+		return d / time.Microsecond * time.Microsecond
+	}
+}
@@ -3,157 +3,138 @@
 package bigfft
 
 import (
+	"encoding/json"
 	"flag"
-	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"testing"
 	"time"
 )
 
 var calibrate = flag.Bool("calibrate", false, "run calibration test")
 
-// measureMul benchmarks math/big versus FFT for a given input size
-// (in bits).
-func measureMul(th int) (tBig, tFFT time.Duration) {
-	bigLoad := func(b *testing.B) { benchmarkMulBig(b, th, th) }
-	fftLoad := func(b *testing.B) { benchmarkMulFFT(b, th, th) }
-
-	res1 := testing.Benchmark(bigLoad)
-	res2 := testing.Benchmark(fftLoad)
-	tBig = time.Duration(res1.NsPerOp())
-	tFFT = time.Duration(res2.NsPerOp())
-	return
-}
-
-func roundDur(d time.Duration) time.Duration {
-	if d > 100*time.Millisecond {
-		return d / time.Millisecond * time.Millisecond
-	} else {
-		return d / time.Microsecond * time.Microsecond
+func TestCalibrate(t *testing.T) {
+	if !*calibrate {
+		t.Log("not calibrating, use -calibrate to do so.")
+		return
+	}
+	c, err := Calibrate(CalibrateOptions{Log: os.Stdout})
+	if err != nil {
+		t.Fatal(err)
 	}
+	out, _ := json.MarshalIndent(c, "", "  ")
+	t.Logf("calibrated configuration:\n%s", out)
 }
 
-func TestCalibrateThreshold(t *testing.T) {
+func TestCalibrateQuick(t *testing.T) {
+	// Results depend on timings, which are unreliable
+	// on loaded machines.
 	if !*calibrate {
-		t.Log("not calibrating, use -calibrate to do so.")
-		return
+		t.Skip("not calibrating, use -calibrate to do so.")
+	}
+	c, err := Calibrate(CalibrateOptions{MinTime: time.Millisecond, MaxK: 5})
+	if err == errCalibrate {
+		// Such short measurements may be too noisy.
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("threshold: %d words, sizes: %d", c.Threshold, c.SizeThresholds)
+	if c.Threshold < 1e3/_W || c.Threshold > 300e3/_W {
+		t.Errorf("threshold %d words out of search bounds", c.Threshold)
+	}
+	for k := 1; k < len(c.SizeThresholds); k++ {
+		if c.SizeThresholds[k] < c.SizeThresholds[k-1] {
+			t.Errorf("size thresholds are not sorted: %d", c.SizeThresholds)
+		}
+	}
+	if n := c.RecursionThreshold; n < 256 || n > 16384 {
+		t.Errorf("recursion threshold %d words out of search bounds", n)
+	}
+	if n := c.FourStepThreshold; n != -1 && n < 65<<5 {
+		t.Errorf("four-step threshold %d words out of search bounds", n)
+	}
+	if n := c.NTTThreshold; n != -1 && (n < 2*c.Threshold || n > 400e3) {
+		t.Errorf("NTT threshold %d words out of search bounds", n)
+	}
+	if n := c.FloatThreshold; n != -1 && (n < 20 || n > c.Threshold) {
+		t.Errorf("float threshold %d words out of search bounds", n)
 	}
 
-	lower := int(1e3)   // math/big is faster at this size.
-	upper := int(300e3) // FFT is faster at this size.
+	// The result is usable.
+	x := new(Int).SetBits(rndNat(5000))
+	if z := NewMultiplier(c).Mul(x, x); z.Cmp(new(Int).Mul(x, x)) != 0 {
+		t.Errorf("incorrect product with calibrated configuration")
+	}
+}
 
-	var sizes [9]int
-	var speedups [9]float64
-	for i := 0; i < 3; i++ {
-		for idx := 1; idx <= 9; idx++ {
-			sz := ((10-idx)*lower + idx*upper) / 10
-			big, fft := measureMul(sz)
-			spd := float64(big) / float64(fft)
-			sizes[idx-1] = sz
-			speedups[idx-1] = spd
-			fmt.Printf("speedup of FFT over math/big at size %d bits: %.2f (%s vs %s)\n",
-				sz, spd, roundDur(big), roundDur(fft))
-		}
-		narrow := false
-		for idx, s := range speedups {
-			if s < .98 {
-				lower = sizes[idx]
-				narrow = true
-			} else {
-				break
+func TestCrossover(t *testing.T) {
+	tests := []struct {
+		name    string
+		speedup func(size int) float64
+		want    int
+	}{
+		{"linear", func(size int) float64 { return float64(size) / 1000 }, 1000},
+		{"step", func(size int) float64 {
+			if size < 1234 {
+				return 0.5
 			}
-		}
-		for idx := range speedups {
-			if speedups[8-idx] > 1.02 {
-				upper = sizes[8-idx]
-				narrow = true
-			} else {
-				break
+			return 2
+		}, 1234},
+		// Measurements are noisy near the crossover.
+		{"noisy", func(size int) float64 {
+			s := float64(size) / 3000
+			if size%2 == 0 {
+				return s * 1.01
 			}
+			return s * 0.99
+		}, 3000},
+	}
+	cal := calibrator{opts: CalibrateOptions{Log: ioutil.Discard}}
+	for _, tt := range tests {
+		got, err := cal.crossover(100, 10000, tt.speedup)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
 		}
-		if lower >= upper {
-			panic("impossible")
-		}
-		if !narrow || (upper-lower) <= 10 {
-			break
+		if d := got - tt.want; d < -tt.want/20 || d > tt.want/20 {
+			t.Errorf("%s: crossover at %d, expected about %d", tt.name, got, tt.want)
 		}
 	}
-	fmt.Printf("sizes: %d\n", sizes)
-	fmt.Printf("speedups: %.2f\n", speedups)
 }
 
-func measureFFTSize(w int, k uint) time.Duration {
-	load := func(b *testing.B) {
-		x := rndNat(w)
-		y := rndNat(w)
-		for i := 0; i < b.N; i++ {
-			m := (w+w)>>k + 1
-			xp := polyFromNat(x, k, m)
-			yp := polyFromNat(y, k, m)
-			rp := xp.Mul(&yp)
-			_ = rp.Int()
-		}
+func TestCalibrateOptions(t *testing.T) {
+	if _, err := Calibrate(CalibrateOptions{MaxK: 40}); err == nil {
+		t.Errorf("expected an error for MaxK=40")
 	}
-	res := testing.Benchmark(load)
-	return time.Duration(res.NsPerOp())
 }
 
-func TestCalibrateFFT(t *testing.T) {
-	if !*calibrate {
-		t.Log("not calibrating, use -calibrate to do so.")
-		return
+func TestConfigJSON(t *testing.T) {
+	c := DefaultConfig()
+	c.Threshold = 1234
+	c.Parallelism = 4
+	c.MemoryLimit = 1 << 30
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c2 Config
+	if err := json.Unmarshal(data, &c2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, c2) {
+		t.Errorf("JSON round trip of %+v gives %+v", c, c2)
 	}
 
-	lows := [...]int{10, 10, 10, 10,
-		20, 50, 100, 200, 500, // 8
-		1000, 2000, 5000, 10000, // 12
-		20000, 50000, 100e3, 200e3, // 16
-	}
-	his := [...]int{100, 100, 100, 200,
-		500, 1000, 2000, 5000, 10000, // 8
-		50e3, 100e3, 200e3, 800e3, // 12
-		2e6, 5e6, 10e6, 20e6, // 16
-	}
-	for k := uint(3); k <= 16; k++ {
-		// Measure the speedup between k and k+1
-		low := lows[k] // FFT of size 1<<k known to be faster
-		hi := his[k]   // FFT of size 2<<k known to be faster
-		var sizes [9]int
-		var speedups [9]float64
-		for i := 0; i < 3; i++ {
-			for idx := 1; idx <= 9; idx++ {
-				sz := ((10-idx)*low + idx*hi) / 10
-				t1, t2 := measureFFTSize(sz, k), measureFFTSize(sz, k+1)
-				spd := float64(t1) / float64(t2)
-				sizes[idx-1] = sz
-				speedups[idx-1] = spd
-				fmt.Printf("speedup of %d vs %d at size %d words: %.2f (%s vs %s)\n",
-					k+1, k, sz, spd, roundDur(t1), roundDur(t2))
-			}
-			narrow := false
-			for idx, s := range speedups {
-				if s < .98 {
-					low = sizes[idx]
-					narrow = true
-				} else {
-					break
-				}
-			}
-			for idx := range speedups {
-				if speedups[8-idx] > 1.02 {
-					hi = sizes[8-idx]
-					narrow = true
-				} else {
-					break
-				}
-			}
-			if low >= hi {
-				panic("impossible")
-			}
-			if !narrow || (hi-low) <= 10 {
-				break
-			}
-		}
-		fmt.Printf("sizes: %d\n", sizes)
-		fmt.Printf("speedups: %.2f\n", speedups)
+	// Missing fields take their default values.
+	var c3 Config
+	if err := json.Unmarshal([]byte(`{"threshold": 3000}`), &c3); err != nil {
+		t.Fatal(err)
+	}
+	c3 = NewMultiplier(c3).Config()
+	if c3.Threshold != 3000 || !reflect.DeepEqual(c3.SizeThresholds, DefaultConfig().SizeThresholds) {
+		t.Errorf("unexpected configuration %+v", c3)
 	}
 }
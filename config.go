@@ -0,0 +1,265 @@
+package bigfft
+
+import (
+	"context"
+	"math/big"
+)
+
+// A Config holds the tunable parameters of multiplication.
+// A zero field selects the default value of that parameter.
+//
+// A Config can be stored as JSON, for example to reuse
+// the result of Calibrate.
+type Config struct {
+	// Threshold is the size (in words) of operands above which
+	// FFT is used over Karatsuba from math/big. For operands more
+	// unbalanced than UnbalancedRatio, it applies to the blocks of
+	// the longer operand, which are 3 times as long as the shorter
+	// one.
+	Threshold int `json:"threshold,omitempty"`
+
+	// SizeThresholds[k] is the maximal size (in bits) of products
+	// computed with a FFT of length 1<<k. Larger products use
+	// the largest length.
+	SizeThresholds []int64 `json:"size_thresholds,omitempty"`
+
+	// UnbalancedRatio is the ratio between operand sizes above which
+	// the longer operand is cut into blocks, so that the shorter one
+	// is only transformed once.
+	UnbalancedRatio int `json:"unbalanced_ratio,omitempty"`
+
+	// RecursionThreshold is the size (in words) of the values of
+	// transforms above which their pointwise products are computed
+	// by a negacyclic FFT instead of math/big. A negative value
+	// disables recursion. Timing a product of values of n words
+	// by math/big and by negacyclic convolution (best of 3 runs of
+	// 20, amd64 Xeon) gave:
+	//
+	//	n          512    1024   2048   4096   8192
+	//	math/big   121µs  348µs  1.05ms 2.60ms 9.51ms
+	//	negacyclic 114µs  245µs  650µs  1.32ms 2.62ms
+	//
+	// and with a threshold of 512, products of 500e6 bits, whose
+	// values have 512 words, took 8.5s instead of 7.7s, hence the
+	// default of 1024. Calibrate measures the crossover.
+	RecursionThreshold int `json:"recursion_threshold,omitempty"`
+
+	// FourStepThreshold is the size (in words) of vectors above
+	// which Fourier transforms are computed in four steps, by
+	// transforms of length about the square root of the length,
+	// so that the memory they use fits in cache. A negative value
+	// disables four-step transforms, which is the default since
+	// they were not consistently faster: transforms of K values
+	// of n words (best of 20 alternate runs, amd64 Xeon) took
+	//
+	//	n, K        64, 2^14  256, 2^12  128, 2^15  512, 2^13
+	//	recursive   21.5ms    15.6ms     84.7ms     62.7ms
+	//	four-step   22.9ms    14.8ms     88.7ms     63.8ms
+	//
+	// Calibrate enables them on machines where they are faster.
+	FourStepThreshold int `json:"four_step_threshold,omitempty"`
+
+	// NTTThreshold is the size (in words) of products up to which
+	// number-theoretic transforms modulo three primes of 63 bits
+	// are used instead of transforms modulo 2^N+1. A negative value
+	// disables them, which is the default since they are slower on
+	// the machines measured so far: Calibrate enables them if they
+	// are faster.
+	NTTThreshold int `json:"ntt_threshold,omitempty"`
+
+	// FloatThreshold is the size (in words) of operands above which
+	// products too small for FFT modulo 2^N+1 are computed by a
+	// floating-point FFT of 16-bit limbs, if its length is at most
+	// 2^14 limbs, instead of math/big. A negative value disables it.
+	// Products of n by n words, timed alternately by both methods
+	// (best of 60 runs of 10, amd64 Xeon), took:
+	//
+	//	n         1500   1600   1700   1800
+	//	math/big  388µs  392µs  460µs  554µs
+	//	float     445µs  411µs  410µs  447µs
+	//
+	// hence the default of 1700 words.
+	FloatThreshold int `json:"float_threshold,omitempty"`
+
+	// Parallelism is the maximal number of goroutines used
+	// by a multiplication.
+	Parallelism int `json:"parallelism,omitempty"`
+
+	// MemoryLimit, if positive, is the maximal amount of memory
+	// (in bytes) that a FFT multiplication may allocate, as estimated
+	// by EstimateMemory. Products needing more silently fall back
+	// to math/big, which is much slower but only needs memory
+	// proportional to the size of operands; no error is reported,
+	// use MulLimit to refuse such products instead.
+	MemoryLimit int64 `json:"memory_limit,omitempty"`
+}
+
+// DefaultConfig returns the configuration used by
+// the functions of the package.
+func DefaultConfig() Config {
+	return Config{
+		// TestCalibrate seems to indicate a threshold of 60kbits on 32-bit
+		// arches and 110kbits on 64-bit arches.
+		Threshold: 1800,
+		SizeThresholds: []int64{0, 0, 0,
+			4 << 10, 8 << 10, 16 << 10, // 5
+			32 << 10, 64 << 10, 1 << 18, 1 << 20, 3 << 20, // 10
+			8 << 20, 30 << 20, 100 << 20, 300 << 20, 600 << 20,
+		},
+		UnbalancedRatio:    8,
+		RecursionThreshold: 1024,
+		FourStepThreshold:  -1,
+		NTTThreshold:       -1,
+		FloatThreshold:     1700,
+		Parallelism:        1,
+	}
+}
+
+var defaultConfig = DefaultConfig()
+
+// withDefaults returns a copy of c where zero fields
+// are replaced by their default values.
+func (c Config) withDefaults() Config {
+	if c.Threshold == 0 {
+		c.Threshold = defaultConfig.Threshold
+	}
+	if len(c.SizeThresholds) == 0 {
+		c.SizeThresholds = defaultConfig.SizeThresholds
+	}
+	c.SizeThresholds = append([]int64(nil), c.SizeThresholds...)
+	if c.UnbalancedRatio == 0 {
+		c.UnbalancedRatio = defaultConfig.UnbalancedRatio
+	}
+	if c.RecursionThreshold == 0 {
+		c.RecursionThreshold = defaultConfig.RecursionThreshold
+	}
+	if c.FourStepThreshold == 0 {
+		c.FourStepThreshold = defaultConfig.FourStepThreshold
+	}
+	if c.NTTThreshold == 0 {
+		c.NTTThreshold = defaultConfig.NTTThreshold
+	}
+	if c.FloatThreshold == 0 {
+		c.FloatThreshold = defaultConfig.FloatThreshold
+	}
+	if c.Parallelism == 0 {
+		c.Parallelism = defaultConfig.Parallelism
+	}
+	return c
+}
+
+// A Multiplier multiplies integers using a given Config.
+// It is safe for concurrent use.
+type Multiplier struct {
+	cfg Config
+}
+
+var defaultMultiplier = NewMultiplier(defaultConfig)
+
+// NewMultiplier returns a Multiplier using configuration c.
+// Later modifications of c do not affect the Multiplier.
+func NewMultiplier(c Config) *Multiplier {
+	return &Multiplier{cfg: c.withDefaults()}
+}
+
+// Config returns the configuration of m, where
+// default values are filled in.
+func (m *Multiplier) Config() Config {
+	c := m.cfg
+	c.SizeThresholds = append([]int64(nil), c.SizeThresholds...)
+	return c
+}
+
+// useFFT reports whether the product of numbers of
+// xwords and ywords words should be computed by FFT.
+//
+// Unbalanced products are computed by FFT if the blocks of the longer
+// operand are, even if the shorter one is below Threshold. Products
+// of 400e3 words by n words (best of 15 alternate runs, amd64 Xeon)
+// took:
+//
+//	n         400   500   600   700   800   900
+//	math/big  65ms  74ms  80ms  99ms  90ms  98ms
+//	FFT       96ms  99ms  87ms  74ms  88ms  89ms
+func (m *Multiplier) useFFT(xwords, ywords int) bool {
+	if xwords < ywords {
+		xwords, ywords = ywords, xwords
+	}
+	if xwords <= m.cfg.Threshold {
+		return false
+	}
+	if m.cfg.unbalanced(xwords, ywords) {
+		if unbalancedBlock*ywords <= m.cfg.Threshold {
+			return false
+		}
+	} else if ywords <= m.cfg.Threshold {
+		return false
+	}
+	if m.cfg.MemoryLimit > 0 && m.cfg.mulMemory(xwords, ywords) > m.cfg.MemoryLimit {
+		return false
+	}
+	return true
+}
+
+// unbalanced reports whether the longer operand of a product of
+// xwords by ywords words, where xwords >= ywords, is cut into blocks.
+func (c *Config) unbalanced(xwords, ywords int) bool {
+	// UnbalancedRatio*ywords may overflow an int on 32-bit systems.
+	return int64(xwords) > int64(c.UnbalancedRatio)*int64(ywords)
+}
+
+// useTransform reports whether the product of numbers of xwords
+// and ywords words should be computed by FFT modulo 2^N+1 or by
+// floating-point FFT, rather than by math/big.
+func (m *Multiplier) useTransform(xwords, ywords int) bool {
+	return m.useFFT(xwords, ywords) || m.cfg.useFloat(xwords, ywords)
+}
+
+func (m *Multiplier) env() *env {
+	e := newEnv(m.cfg.Parallelism)
+	e.cfg = &m.cfg
+	return e
+}
+
+// Mul computes the product x*y and returns it.
+func (m *Multiplier) Mul(x, y *big.Int) *big.Int {
+	return m.MulTo(new(big.Int), x, y)
+}
+
+// MulTo computes the product x*y, stores it in z and returns z.
+// Like the Mul method of *big.Int, it reuses the storage of z
+// when it is large enough, and z may alias x or y.
+func (m *Multiplier) MulTo(z, x, y *big.Int) *big.Int {
+	if m.useTransform(len(x.Bits()), len(y.Bits())) {
+		return mulFFTTo(m.env(), z, x, y)
+	}
+	return z.Mul(x, y)
+}
+
+// Sqr computes the square x*x and returns it.
+func (m *Multiplier) Sqr(x *big.Int) *big.Int {
+	xwords := len(x.Bits())
+	if m.useTransform(xwords, xwords) {
+		zb := fftsqr(m.env(), nil, x.Bits())
+		return new(big.Int).SetBits(zb)
+	}
+	return new(big.Int).Mul(x, x)
+}
+
+// MulContext computes the product x*y and returns it.
+// If ctx is done before the product is computed, the computation
+// is abandoned and MulContext returns ctx.Err().
+func (m *Multiplier) MulContext(ctx context.Context, x, y *big.Int) (*big.Int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !m.useTransform(len(x.Bits()), len(y.Bits())) {
+		return new(big.Int).Mul(x, y), nil
+	}
+	e := m.env().withContext(ctx)
+	z := mulFFTTo(e, new(big.Int), x, y)
+	if e.cancelled() {
+		return nil, ctx.Err()
+	}
+	return z, nil
+}
@@ -0,0 +1,82 @@
+package bigfft
+
+import (
+	"testing"
+)
+
+func TestMultiplierConfigs(t *testing.T) {
+	// Smaller FFT lengths than the default.
+	small := DefaultConfig()
+	for i := range small.SizeThresholds {
+		small.SizeThresholds[i] *= 4
+	}
+	configs := []Config{
+		{},
+		DefaultConfig(),
+		{Threshold: 200},
+		{Threshold: 200, SizeThresholds: small.SizeThresholds},
+		{Parallelism: 3},
+		{UnbalancedRatio: 2},
+		{RecursionThreshold: 8},
+		{RecursionThreshold: 8, SizeThresholds: small.SizeThresholds, Parallelism: 3},
+		{FourStepThreshold: 1},
+		{FourStepThreshold: 1, Parallelism: 3},
+		{MemoryLimit: 1 << 20},
+		{NTTThreshold: 1 << 20},
+		{NTTThreshold: 1 << 20, Threshold: 200, Parallelism: 3},
+		{FloatThreshold: 10},
+		{FloatThreshold: 10, Parallelism: 3},
+	}
+	sizes := []int{1e3, 20e3, 200e3, 2e6}
+	var x, y Int
+	for i, c := range configs {
+		m := NewMultiplier(c)
+		for _, size1 := range sizes {
+			for _, size2 := range sizes {
+				x.SetBits(rndNat(size1 / _W))
+				y.SetBits(rndNat(size2 / _W))
+				x.Neg(&x)
+				want := new(Int).Mul(&x, &y)
+				if z := m.Mul(&x, &y); z.Cmp(want) != 0 {
+					t.Errorf("config %d: incorrect product for sizes %d, %d", i, size1, size2)
+				}
+			}
+			want := new(Int).Mul(&x, &x)
+			if z := m.Sqr(&x); z.Cmp(want) != 0 {
+				t.Errorf("config %d: incorrect square for size %d", i, size1)
+			}
+		}
+	}
+}
+
+func TestMultiplierConfig(t *testing.T) {
+	c := Config{Threshold: 100, Parallelism: 2}
+	m := NewMultiplier(c)
+	c2 := m.Config()
+	if c2.Threshold != 100 || c2.Parallelism != 2 {
+		t.Errorf("Config() = %+v does not match %+v", c2, c)
+	}
+	def := DefaultConfig()
+	if c2.UnbalancedRatio != def.UnbalancedRatio || len(c2.SizeThresholds) != len(def.SizeThresholds) {
+		t.Errorf("Config() = %+v does not have default values", c2)
+	}
+	// The returned configuration is a copy.
+	c2.SizeThresholds[5] = 1
+	if m.Config().SizeThresholds[5] == 1 {
+		t.Errorf("modifying the result of Config() affects the Multiplier")
+	}
+}
+
+func TestConfigFFTSize(t *testing.T) {
+	c := DefaultConfig()
+	for words := 100; words < 1e7; words = words*3/2 + 1 {
+		k, m := c.fftSize(words)
+		if m<<k <= words {
+			t.Errorf("fftSize(%d) = %d, %d is too small", words, k, m)
+		}
+		// Chunks are as large as possible for the coefficient length.
+		if n := valueSize(k, words>>k+1, 2); valueSize(k, m, 2) != n || valueSize(k, m+1, 2) == n {
+			t.Errorf("fftSize(%d) = %d, %d does not use the largest chunks", words, k, m)
+		}
+	}
+}
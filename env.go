@@ -0,0 +1,219 @@
+package bigfft
+
+import (
+	"context"
+	"sync"
+)
+
+// An env holds the execution parameters of a FFT multiplication.
+// Polynomials and their values carry a pointer to it, so that
+// all stages of a multiplication share it.
+//
+// A nil *env is valid and runs everything serially
+// in the calling goroutine.
+type env struct {
+	// sem holds a token for each goroutine running
+	// in addition to the calling goroutine.
+	sem chan struct{}
+	// cfg holds the tunable parameters.
+	cfg *Config
+	// done is closed when the computation must be aborted.
+	// When it is, the stages of the computation return early
+	// and their results are meaningless.
+	done <-chan struct{}
+	// arena, if not nil, provides the temporary buffers
+	// of the calling goroutine.
+	arena *arena
+	// stats, if not nil, collects statistics about
+	// the multiplication.
+	stats *Stats
+	// bytes, if not nil, counts the memory allocated by the
+	// environment of recursive products, which may be used by
+	// several goroutines, instead of stats.
+	bytes *int64
+}
+
+// newEnv returns an env using at most procs goroutines.
+func newEnv(procs int) *env {
+	e := new(env)
+	if procs > 1 {
+		e.sem = make(chan struct{}, procs-1)
+	}
+	return e
+}
+
+// config returns the configuration of e.
+func (e *env) config() *Config {
+	if e == nil || e.cfg == nil {
+		return &defaultConfig
+	}
+	return e.cfg
+}
+
+// withContext makes e abort computations when ctx is done.
+func (e *env) withContext(ctx context.Context) *env {
+	e.done = ctx.Done()
+	return e
+}
+
+// nat returns a buffer of n words with unspecified contents.
+// Buffers are taken from the arena of e if it has one: they must
+// only be used by the calling goroutine, until the arena is
+// released to a mark taken before.
+func (e *env) nat(n int) nat {
+	e.allocated(int64(n) * wordBytes)
+	if e == nil || e.arena == nil {
+		return make(nat, n)
+	}
+	return e.arena.nat(n)
+}
+
+// values returns a vector of 1<<k numbers of n+1 words
+// with unspecified contents, allocated like e.nat.
+func (e *env) values(k uint, n int) []fermat {
+	return e.vector(1<<k, n)
+}
+
+// vector returns a vector of count numbers of n+1 words
+// with unspecified contents, allocated like e.nat.
+func (e *env) vector(count, n int) []fermat {
+	var v []fermat
+	e.allocated(int64(count) * headerBytes)
+	if e == nil || e.arena == nil {
+		v = make([]fermat, count)
+	} else {
+		v = e.arena.fermatSlice(count)
+	}
+	bits := e.nat((n + 1) * count)
+	for i := range v {
+		v[i] = fermat(bits[i*(n+1) : (i+1)*(n+1)])
+	}
+	return v
+}
+
+// nats returns a slice of n nat, allocated like e.nat.
+func (e *env) nats(n int) []nat {
+	e.allocated(int64(n) * headerBytes)
+	if e == nil || e.arena == nil {
+		return make([]nat, n)
+	}
+	return e.arena.natSlice(n)
+}
+
+// uint64s returns a slice of n uint64 with unspecified contents,
+// allocated like e.nat.
+func (e *env) uint64s(n int) []uint64 {
+	e.allocated(int64(n) * 8)
+	if e == nil || e.arena == nil {
+		return make([]uint64, n)
+	}
+	return e.arena.uint64Slice(n)
+}
+
+// complexes returns a slice of n complex128 with unspecified
+// contents, allocated like e.nat.
+func (e *env) complexes(n int) []complex128 {
+	e.allocated(int64(n) * 16)
+	if e == nil || e.arena == nil {
+		return make([]complex128, n)
+	}
+	return e.arena.complexSlice(n)
+}
+
+// mark records the state of the arena of e, if any.
+func (e *env) mark() arenaMark {
+	if e == nil || e.arena == nil {
+		return arenaMark{}
+	}
+	return e.arena.mark()
+}
+
+// free gives back to the arena of e the buffers allocated
+// since mark.
+func (e *env) free(mark arenaMark) {
+	if e == nil || e.arena == nil {
+		return
+	}
+	e.arena.release(mark)
+}
+
+// cancelGrain is the amount of work (in words) of FFT steps
+// which check for cancellation before running.
+const cancelGrain = 1 << 16
+
+// cancelled reports whether the computation has been aborted.
+func (e *env) cancelled() bool {
+	if e == nil || e.done == nil {
+		return false
+	}
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// parallelGrain is the amount of work (in words) below which
+// a task is not worth running in a separate goroutine.
+const parallelGrain = 1 << 14
+
+// parallel reports whether e may run tasks in other goroutines.
+func (e *env) parallel() bool {
+	return e != nil && e.sem != nil
+}
+
+// acquire reserves a goroutine for a task processing the given
+// amount of words. It reports false if the task is too small
+// or if no goroutine is available, in which case the caller
+// must run the task itself.
+func (e *env) acquire(words int) bool {
+	if !e.parallel() || words < parallelGrain {
+		return false
+	}
+	select {
+	case e.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release gives back a goroutine reserved by acquire.
+func (e *env) release() { <-e.sem }
+
+// parallelRange calls f on consecutive subranges covering [0, count),
+// running them in other goroutines when possible. unit is the amount
+// of work (in words) for each element of the range.
+// Callers should only use it if e.parallel() is true, to avoid
+// allocating the closure f in the serial case.
+func (e *env) parallelRange(count, unit int, f func(lo, hi int)) {
+	chunks := 1
+	if e.parallel() {
+		chunks = cap(e.sem) + 1
+	}
+	if limit := count * unit / parallelGrain; chunks > limit {
+		chunks = limit
+	}
+	if chunks <= 1 {
+		f(0, count)
+		return
+	}
+	var wg sync.WaitGroup
+	lo := 0
+	for c := 1; c <= chunks; c++ {
+		hi := c * count / chunks
+		if c < chunks && e.acquire(parallelGrain) {
+			wg.Add(1)
+			go func(lo, hi int) {
+				f(lo, hi)
+				e.release()
+				wg.Done()
+			}(lo, hi)
+		} else {
+			f(lo, hi)
+		}
+		lo = hi
+	}
+	wg.Wait()
+}
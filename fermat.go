@@ -166,6 +166,39 @@ func (z fermat) Mul(x, y fermat) fermat {
 		}
 		z = zb
 	}
+	return z.reduce(n)
+}
+
+// Sqr computes x*x mod 2^n+1. It is cheaper than Mul(x, x)
+// because math/big uses a dedicated squaring algorithm.
+func (z fermat) Sqr(x fermat) fermat {
+	n := len(x) - 1
+	if n < 30 {
+		z = z[:2*n+2]
+		basicMul(z, x, x)
+		z = z[:2*n+1]
+	} else {
+		var xi, zi big.Int
+		xi.SetBits(x)
+		zi.SetBits(z)
+		// Passing the same operand twice selects squaring in math/big.
+		zb := zi.Mul(&xi, &xi).Bits()
+		if len(zb) <= n {
+			// Short product.
+			copy(z, zb)
+			for i := len(zb); i < len(z); i++ {
+				z[i] = 0
+			}
+			return z
+		}
+		z = zb
+	}
+	return z.reduce(n)
+}
+
+// reduce normalizes z, the product of two numbers modulo 2^n+1,
+// to a fermat of length n+1.
+func (z fermat) reduce(n int) fermat {
 	// len(z) is at most 2n+1.
 	if len(z) > 2*n+1 {
 		panic("len(z) > 2n+1")
@@ -151,3 +151,14 @@ func TestFermatMul(t *testing.T) {
 		compare(t, fmt.Sprintf("mulTests[%d]", i), z, item.c)
 	}
 }
+
+func TestFermatSqr(t *testing.T) {
+	for i, item := range mulTests {
+		if nat(item.a).String() != nat(item.b).String() {
+			continue
+		}
+		z := make(fermat, 3*len(item.a))
+		z = z.Sqr(item.a)
+		compare(t, fmt.Sprintf("mulTests[%d]", i), z, item.c)
+	}
+}
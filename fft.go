@@ -5,6 +5,7 @@
 package bigfft
 
 import (
+	"context"
 	"math/big"
 	"unsafe"
 )
@@ -19,64 +20,203 @@ func (n nat) String() string {
 	return v.String()
 }
 
-// fftThreshold is the size (in words) above which FFT is used over
-// Karatsuba from math/big.
-//
-// TestCalibrate seems to indicate a threshold of 60kbits on 32-bit
-// arches and 110kbits on 64-bit arches.
-var fftThreshold = 1800
-
 // Mul computes the product x*y and returns z.
 // It can be used instead of the Mul method of
 // *big.Int from math/big package.
 func Mul(x, y *big.Int) *big.Int {
-	xwords := len(x.Bits())
-	ywords := len(y.Bits())
-	if xwords > fftThreshold && ywords > fftThreshold {
-		return mulFFT(x, y)
-	}
-	return new(big.Int).Mul(x, y)
+	return defaultMultiplier.Mul(x, y)
+}
+
+// MulTo computes the product x*y, stores it in z and returns z.
+// Like the Mul method of *big.Int, it reuses the storage of z
+// when it is large enough, and z may alias x or y.
+func MulTo(z, x, y *big.Int) *big.Int {
+	return defaultMultiplier.MulTo(z, x, y)
+}
+
+// MulParallel computes the product x*y and returns it, like Mul,
+// but runs the Fourier transforms and pointwise products using
+// at most procs goroutines. The result does not depend on procs.
+func MulParallel(x, y *big.Int, procs int) *big.Int {
+	c := defaultConfig
+	c.Parallelism = procs
+	return NewMultiplier(c).Mul(x, y)
+}
+
+// MulContext computes the product x*y and returns it, like Mul.
+// If ctx is done before the product is computed, the computation
+// is abandoned and MulContext returns ctx.Err().
+func MulContext(ctx context.Context, x, y *big.Int) (*big.Int, error) {
+	return defaultMultiplier.MulContext(ctx, x, y)
 }
 
 func mulFFT(x, y *big.Int) *big.Int {
+	return mulFFTTo(nil, new(big.Int), x, y)
+}
+
+func mulFFTTo(e *env, z, x, y *big.Int) *big.Int {
+	neg := x.Sign()*y.Sign() < 0
 	var xb, yb nat = x.Bits(), y.Bits()
-	zb := fftmul(xb, yb)
-	z := new(big.Int)
+	// The inputs are no longer used when the result is
+	// written, so z's words can be reused even if it aliases x or y.
+	zb := fftmulTo(e, z.Bits(), xb, yb)
 	z.SetBits(zb)
-	if x.Sign()*y.Sign() < 0 {
+	if neg {
 		z.Neg(z)
 	}
 	return z
 }
 
+// Sqr computes the square x*x and returns it.
+// It is faster than Mul(x, x) because the operand
+// is only transformed once.
+func Sqr(x *big.Int) *big.Int {
+	return defaultMultiplier.Sqr(x)
+}
+
 // A FFT size of K=1<<k is adequate when K is about 2*sqrt(N) where
 // N = x.Bitlen() + y.Bitlen().
 
 func fftmul(x, y nat) nat {
-	k, m := fftSize(x, y)
-	xp := polyFromNat(x, k, m)
-	yp := polyFromNat(y, k, m)
-	rp := xp.Mul(&yp)
-	return rp.Int()
+	return fftmulTo(nil, nil, x, y)
 }
 
-// fftSizeThreshold[i] is the maximal size (in bits) where we should use
-// fft size i.
-var fftSizeThreshold = [...]int64{0, 0, 0,
-	4 << 10, 8 << 10, 16 << 10, // 5 
-	32 << 10, 64 << 10, 1 << 18, 1 << 20, 3 << 20, // 10
-	8 << 20, 30 << 20, 100 << 20, 300 << 20, 600 << 20,
+// unbalancedBlock is the size of blocks, relative to the shorter
+// operand, so that transforms cover about 4 times its size. Timing
+// fftmulUnbalanced with this constant set to 1, 2, 3, 4 and 6 (best
+// of 4 runs on an amd64 Xeon) gave:
+//
+//	1e6 by 20e6 bits:  104ms  93ms  86ms  103ms  110ms
+//	1e5 by 5e6 bits:    33ms  19ms  15ms   15ms   17ms
+const unbalancedBlock = 3
+
+// fftmulTo computes x*y, using the storage of z for the
+// result if its capacity is large enough.
+func fftmulTo(e *env, z, x, y nat) nat {
+	if len(x) < len(y) {
+		x, y = y, x
+	}
+	c := e.config()
+	if c.useFloat(len(x), len(y)) {
+		if zf, ok := floatmulTo(e, z, x, y); ok {
+			return zf
+		}
+	}
+	if c.useNTT(len(x) + len(y)) {
+		return nttmulTo(e, z, x, y)
+	}
+	if c.unbalanced(len(x), len(y)) {
+		return fftmulUnbalanced(e, z, x, y)
+	}
+	k, m := c.fftSize(len(x) + len(y))
+	e.setSize(k, m, 1)
+	defer e.free(e.mark())
+	xp := e.polyFromNat(x, k, m)
+	yp := e.polyFromNat(y, k, m)
+	z = xp.mulTo(z, &yp, len(x)+len(y))
+	if e.cancelled() {
+		return nil
+	}
+	return z
+}
+
+// fftmulUnbalanced computes x*y when x is much longer than y.
+// x is cut into blocks of a few times the length of y, so that y
+// is transformed only once, and the partial products are added
+// at their offsets.
+func fftmulUnbalanced(e *env, z, x, y nat) nat {
+	k, m := e.config().fftSize(unbalancedBlock*len(y) + len(y))
+	defer e.free(e.mark())
+	f := newFixedValues(e, y, k, m)
+	return f.mulBlocks(e, z, x)
+}
+
+// mulBlocks computes x*y, where y is the number transformed in f, by
+// cutting x into the largest blocks that fit in the transform, using
+// the storage of z for the result if its capacity is large enough.
+func (f *fixedValues) mulBlocks(e *env, z, x nat) nat {
+	k, m, y := f.k, f.m, f.y
+	// The largest block such that f.fits(block).
+	block := (1<<k - 1 - len(y)/m) * m
+	e.setSize(k, m, (len(x)+block-1)/block)
+
+	if alias(z, x) || alias(z, y) {
+		z = nil // z is written before x is fully read.
+	}
+	if cap(z) >= len(x)+len(y) {
+		z = z[:len(x)+len(y)]
+		for i := range z {
+			z[i] = 0
+		}
+	} else {
+		z = make(nat, len(x)+len(y))
+		e.allocated(int64(len(z)) * wordBytes)
+	}
+	// The product of a block by y has at most block+len(y) words.
+	buf := e.nat(block + len(y))
+	for off := 0; off < len(x); off += block {
+		if e.cancelled() {
+			return nil
+		}
+		end := off + block
+		if end > len(x) {
+			end = len(x)
+		}
+		mark := e.mark()
+		buf = f.mulTo(e, buf, x[off:end])
+		e.free(mark)
+		t := e.clock()
+		zp := z[off:]
+		c := addVV(zp[:len(buf)], zp[:len(buf)], buf)
+		if c != 0 {
+			addVW(zp[len(buf):], zp[len(buf):], c)
+		}
+		e.lap(t, stageRecompose)
+	}
+	return trim(z)
+}
+
+// alias reports whether x and y share the same base array.
+func alias(x, y nat) bool {
+	return cap(x) > 0 && cap(y) > 0 && &x[0:cap(x)][cap(x)-1] == &y[0:cap(y)][cap(y)-1]
+}
+
+// fftsqr computes x*x, using the storage of z for the
+// result if its capacity is large enough.
+func fftsqr(e *env, z, x nat) nat {
+	if e.config().useFloat(len(x), len(x)) {
+		if zf, ok := floatmulTo(e, z, x, nil); ok {
+			return zf
+		}
+	}
+	if e.config().useNTT(2 * len(x)) {
+		return nttmulTo(e, z, x, nil)
+	}
+	k, m := e.config().fftSize(2 * len(x))
+	e.setSize(k, m, 1)
+	defer e.free(e.mark())
+	xp := e.polyFromNat(x, k, m)
+	z = xp.mulTo(z, nil, 2*len(x))
+	if e.cancelled() {
+		return nil
+	}
+	return z
 }
 
 // returns the FFT length k, m the number of words per chunk
 // such that m << k is larger than the number of words
 // in x*y.
 func fftSize(x, y nat) (k uint, m int) {
-	words := len(x) + len(y)
+	return defaultConfig.fftSize(len(x) + len(y))
+}
+
+// fftSize returns the FFT length k and the number m of words
+// per chunk to compute a product of the given number of words.
+func (c *Config) fftSize(words int) (k uint, m int) {
 	bits := int64(words) * int64(_W)
-	k = uint(len(fftSizeThreshold))
-	for i := range fftSizeThreshold {
-		if fftSizeThreshold[i] > bits {
+	k = uint(len(c.SizeThresholds))
+	for i := range c.SizeThresholds {
+		if c.SizeThresholds[i] > bits {
 			k = uint(i)
 			break
 		}
@@ -84,6 +224,13 @@ func fftSize(x, y nat) (k uint, m int) {
 	// The 1<<k chunks of m words must have N bits so that
 	// 2^N-1 is larger than x*y. That is, m<<k > words
 	m = words>>k + 1
+	// Use the largest chunks having the same coefficient length:
+	// products then have fewer coefficients, and truncated
+	// transforms only compute the needed ones.
+	n := valueSize(k, m, 2)
+	if mm := (n*_W - int(k) - 1) / (2 * _W); mm > m {
+		m = mm
+	}
 	return
 }
 
@@ -109,21 +256,33 @@ func valueSize(k uint, m int, extra uint) int {
 // If P = a[0] + a[1] x + ... a[n] x^(K-1), the associated natural number
 // is P(b^m).
 type poly struct {
-	k uint  // k is such that K = 1<<k.
-	m int   // the m such that P(b^m) is the original number.
-	a []nat // a slice of at most K m-word coefficients.
+	k   uint  // k is such that K = 1<<k.
+	m   int   // the m such that P(b^m) is the original number.
+	a   []nat // a slice of at most K m-word coefficients.
+	env *env  // the execution environment of transforms.
 }
 
 // polyFromNat slices the number x into a polynomial
 // with 1<<k coefficients made of m words.
 func polyFromNat(x nat, k uint, m int) poly {
-	p := poly{k: k, m: m}
+	var e *env
+	return e.polyFromNat(x, k, m)
+}
+
+// polyFromNat is like the polyFromNat function, but allocates
+// the polynomial in e, which is used by its transforms.
+func (e *env) polyFromNat(x nat, k uint, m int) poly {
+	p := poly{k: k, m: m, env: e}
 	length := len(x)/m + 1
-	p.a = make([]nat, length)
+	p.a = e.nats(length)
 	for i := range p.a {
 		if len(x) < m {
-			p.a[i] = make(nat, m)
-			copy(p.a[i], x)
+			a := e.nat(m)
+			copy(a, x)
+			for j := len(x); j < m; j++ {
+				a[j] = 0
+			}
+			p.a[i] = a
 			break
 		}
 		p.a[i] = x[:m]
@@ -134,22 +293,45 @@ func polyFromNat(x nat, k uint, m int) poly {
 
 // Int evaluates back a poly to its integer value.
 func (p *poly) Int() nat {
-	length := len(p.a)*p.m + 1
-	if na := len(p.a); na > 0 {
-		length += len(p.a[na-1])
-	}
-	n := make(nat, length)
+	return p.IntTo(nil)
+}
+
+// IntTo is like Int but reuses the storage of z
+// if its capacity is large enough.
+func (p *poly) IntTo(z nat) nat {
+	defer p.env.lap(p.env.clock(), stageRecompose)
 	m := p.m
-	np := n
+	// Coefficients may have high zero words: only count
+	// significant words to size the result.
+	length := 1
+	for i := range p.a {
+		if l := len(trim(p.a[i])); l > 0 && i*m+l+1 > length {
+			length = i*m + l + 1
+		}
+	}
+	var n nat
+	if cap(z) >= length {
+		n = z[:length]
+		for i := range n {
+			n[i] = 0
+		}
+	} else {
+		n = make(nat, length)
+		p.env.allocated(int64(length) * wordBytes)
+	}
 	for i := range p.a {
-		l := len(p.a[i])
-		c := addVV(np[:l], np[:l], p.a[i])
+		a := trim(p.a[i])
+		l := len(a)
+		if l == 0 {
+			continue
+		}
+		np := n[i*m:]
+		c := addVV(np[:l], np[:l], a)
 		if np[l] < ^big.Word(0) {
 			np[l] += c
 		} else {
 			addVW(np[l:], np[l:], c)
 		}
-		np = np[m:]
 	}
 	n = trim(n)
 	return n
@@ -171,72 +353,222 @@ func (p *poly) Mul(q *poly) poly {
 	// * some power of 2 is a K-th root of unity when n is a multiple of K/2.
 	// * 2 itself is a square (see fermat.ShiftHalf)
 	n := valueSize(p.k, p.m, 2)
+	if length := tftLength(len(p.a) + len(q.a) - 1); length < 1<<p.k {
+		return p.mulTruncated(q, n, length)
+	}
 
-	pv, qv := p.Transform(n), q.Transform(n)
+	pv := p.Transform(n)
+	if p.env.cancelled() {
+		return poly{}
+	}
+	qv := q.Transform(n)
+	if p.env.cancelled() {
+		return poly{}
+	}
 	rv := pv.Mul(&qv)
+	if p.env.cancelled() {
+		return poly{}
+	}
 	r := rv.InvTransform()
 	r.m = p.m
 	return r
 }
 
+// Sqr computes p*p modulo X^K-1, where K = 1<<p.k.
+// It only needs one forward Fourier transform.
+func (p *poly) Sqr() poly {
+	n := valueSize(p.k, p.m, 2)
+	if length := tftLength(2*len(p.a) - 1); length < 1<<p.k {
+		return p.mulTruncated(nil, n, length)
+	}
+
+	pv := p.Transform(n)
+	if p.env.cancelled() {
+		return poly{}
+	}
+	rv := pv.Sqr()
+	if p.env.cancelled() {
+		return poly{}
+	}
+	r := rv.InvTransform()
+	r.m = p.m
+	return r
+}
+
+// mulTo computes p*q, or p*p if q is nil, and returns its value,
+// which has at most length words, using the storage of z if its
+// capacity is large enough. Unlike Mul followed by IntTo, the product
+// polynomial is not built: coefficients are added to the result as
+// soon as they are computed by the inverse transform.
+func (p *poly) mulTo(z nat, q *poly, length int) nat {
+	n := valueSize(p.k, p.m, 2)
+	var r poly
+	if q == nil {
+		if l := tftLength(2*len(p.a) - 1); l < 1<<p.k {
+			r = p.mulTruncated(nil, n, l)
+		}
+	} else if l := tftLength(len(p.a) + len(q.a) - 1); l < 1<<p.k {
+		r = p.mulTruncated(q, n, l)
+	}
+	if r.a != nil {
+		if p.env.cancelled() {
+			return nil
+		}
+		return r.IntTo(z)
+	}
+
+	pv := p.Transform(n)
+	if p.env.cancelled() {
+		return nil
+	}
+	var rv polValues
+	if q == nil {
+		rv = pv.Sqr()
+	} else {
+		qv := q.Transform(n)
+		if p.env.cancelled() {
+			return nil
+		}
+		rv = pv.Mul(&qv)
+	}
+	if p.env.cancelled() {
+		return nil
+	}
+	// The values of p are no longer needed.
+	return rv.invTransformTo(z, p.m, length, pv.values)
+}
+
 // A polValues represents the value of a poly at the powers of a
 // K-th root of unity θ=2^(l/2) in Z/(b^n+1)Z, where b^n = 2^(K/4*l).
 type polValues struct {
 	k      uint     // k is such that K = 1<<k.
 	n      int      // the length of coefficients, n*_W a multiple of K/4.
 	values []fermat // a slice of K (n+1)-word values
+	env    *env     // the execution environment of transforms.
 }
 
 // Transform evaluates p at θ^i for i = 0...K-1, where
 // θ is a K-th primitive root of unity in Z/(b^n+1)Z.
 func (p *poly) Transform(n int) polValues {
+	defer p.env.lap(p.env.clock(), stageForward)
 	k := p.k
-	inputbits := make([]big.Word, (n+1)<<k)
-	input := make([]fermat, 1<<k)
-	// Now computed q(ω^i) for i = 0 ... K-1
-	valbits := make([]big.Word, (n+1)<<k)
-	values := make([]fermat, 1<<k)
-	for i := range values {
-		input[i] = inputbits[i*(n+1) : (i+1)*(n+1)]
+	values := p.env.values(k, n)
+	// The input is only needed during the transform.
+	mark := p.env.mark()
+	input := p.env.values(k, n)
+	for i, in := range input {
+		l := 0
 		if i < len(p.a) {
-			copy(input[i], p.a[i])
+			l = copy(in, p.a[i])
+		}
+		for j := l; j < len(in); j++ {
+			in[j] = 0
 		}
-		values[i] = fermat(valbits[i*(n+1) : (i+1)*(n+1)])
 	}
-	fourier(values, input, false, n, k)
-	return polValues{k, n, values}
+	// Now computed q(ω^i) for i = 0 ... K-1
+	p.env.fourier(values, input, false, n, k)
+	p.env.free(mark)
+	return polValues{k, n, values, p.env}
 }
 
 // InvTransform reconstructs p (modulo X^K - 1) from its
 // values at θ^i for i = 0..K-1.
 func (v *polValues) InvTransform() poly {
+	defer v.env.lap(v.env.clock(), stageInverse)
 	k, n := v.k, v.n
 
 	// Perform an inverse Fourier transform to recover p.
-	pbits := make([]big.Word, (n+1)<<k)
-	p := make([]fermat, 1<<k)
-	for i := range p {
-		p[i] = fermat(pbits[i*(n+1) : (i+1)*(n+1)])
+	p := v.env.values(k, n)
+	v.env.fourier(p, v.values, true, n, k)
+	// Divide by K to recover p.
+	a := v.env.nats(1 << k)
+	if v.env.parallel() {
+		v.env.parallelRange(len(p), n+1, func(lo, hi int) {
+			shiftValues(a[lo:hi], p[lo:hi], -int(k), 0, make(fermat, n+1))
+		})
+	} else {
+		mark := v.env.mark()
+		shiftValues(a, p, -int(k), 0, fermat(v.env.nat(n+1)))
+		v.env.free(mark)
 	}
-	fourier(p, v.values, true, n, k)
-	// Divide by K, and untwist q to recover p.
-	u := make(fermat, n+1)
-	a := make([]nat, 1<<k)
-	for i := range p {
-		u.Shift(p[i], -int(k))
-		copy(p[i], u)
-		a[i] = nat(p[i])
+	return poly{k: k, m: 0, a: a, env: v.env}
+}
+
+// invTransformTo computes the inverse transform of v in dst, a vector
+// of K values, and returns the value at b^m of the polynomial, which has
+// at most length words, using the storage of z if its capacity is large
+// enough. Each coefficient is added to the result as soon as it is
+// divided by K.
+func (v *polValues) invTransformTo(z nat, m, length int, dst []fermat) nat {
+	t := v.env.clock()
+	k, n := v.k, v.n
+	v.env.fourier(dst, v.values, true, n, k)
+	if v.env.cancelled() {
+		return nil
+	}
+	if v.env.parallel() {
+		// Divide by K in parallel, leaving only additions.
+		v.env.parallelRange(len(dst), n+1, func(lo, hi int) {
+			u := make(fermat, n+1)
+			for _, c := range dst[lo:hi] {
+				u.Shift(c, -int(k))
+				copy(c, u)
+			}
+		})
+	}
+	t = v.env.lap(t, stageInverse)
+	defer v.env.lap(t, stageRecompose)
+
+	if cap(z) >= length {
+		z = z[:length]
+		for i := range z {
+			z[i] = 0
+		}
+	} else {
+		z = make(nat, length)
+		v.env.allocated(int64(length) * wordBytes)
 	}
-	return poly{k: k, m: 0, a: a}
+	mark := v.env.mark()
+	u := fermat(v.env.nat(n + 1))
+	for i := 0; i < len(dst) && i*m < length; i++ {
+		c := dst[i]
+		if !v.env.parallel() {
+			u.Shift(c, -int(k))
+			c = u
+		}
+		// Higher words of coefficients are zero, since
+		// the product has at most length words.
+		zi := z[i*m:]
+		a := trim(nat(c))
+		if len(a) > len(zi) {
+			a = a[:len(zi)]
+		}
+		l := len(a)
+		if l == 0 {
+			continue
+		}
+		carry := addVV(zi[:l], zi[:l], a)
+		if carry == 0 {
+			continue
+		}
+		if zi[l] < ^big.Word(0) {
+			zi[l] += carry
+		} else {
+			addVW(zi[l:], zi[l:], carry)
+		}
+	}
+	v.env.free(mark)
+	return trim(z)
 }
 
 // NTransform evaluates p at θω^i for i = 0...K-1, where
 // θ is a (2K)-th primitive root of unity in Z/(b^n+1)Z
 // and ω = θ².
 func (p *poly) NTransform(n int) polValues {
+	defer p.env.lap(p.env.clock(), stageForward)
 	k := p.k
-	if len(p.a) >= 1<<k {
-		panic("Transform: len(p.a) >= 1<<k")
+	if len(p.a) > 1<<k {
+		panic("NTransform: len(p.a) > 1<<k")
 	}
 	// θ is represented as a shift.
 	θshift := (n * _W) >> k
@@ -244,127 +576,306 @@ func (p *poly) NTransform(n int) polValues {
 	// p(θx) = q(x) where
 	// q(x) = a_0 + θa_1 x + ... + θ^(K-1) a_{K-1} x^(K-1)
 	//
+	values := p.env.values(k, n)
 	// Twist p by θ to obtain q.
-	tbits := make([]big.Word, (n+1)<<k)
-	twisted := make([]fermat, 1<<k)
-	src := make(fermat, n+1)
+	mark := p.env.mark()
+	twisted := p.env.values(k, n)
+	src := fermat(p.env.nat(n + 1))
 	for i := range twisted {
-		twisted[i] = fermat(tbits[i*(n+1) : (i+1)*(n+1)])
 		if i < len(p.a) {
 			for i := range src {
 				src[i] = 0
 			}
 			copy(src, p.a[i])
 			twisted[i].Shift(src, θshift*i)
+		} else {
+			for j := range twisted[i] {
+				twisted[i][j] = 0
+			}
 		}
 	}
 
 	// Now computed q(ω^i) for i = 0 ... K-1
-	valbits := make([]big.Word, (n+1)<<k)
-	values := make([]fermat, 1<<k)
-	for i := range values {
-		values[i] = fermat(valbits[i*(n+1) : (i+1)*(n+1)])
-	}
-	fourier(values, twisted, false, n, k)
-	return polValues{k, n, values}
+	p.env.fourier(values, twisted, false, n, k)
+	p.env.free(mark)
+	return polValues{k, n, values, p.env}
 }
 
 // InvTransform reconstructs a polynomial from its values at
 // roots of x^K+1. The m field of the returned polynomial
 // is unspecified.
 func (v *polValues) InvNTransform() poly {
+	defer v.env.lap(v.env.clock(), stageInverse)
 	k := v.k
 	n := v.n
 	θshift := (n * _W) >> k
 
 	// Perform an inverse Fourier transform to recover q.
-	qbits := make([]big.Word, (n+1)<<k)
-	q := make([]fermat, 1<<k)
-	for i := range q {
-		q[i] = fermat(qbits[i*(n+1) : (i+1)*(n+1)])
-	}
-	fourier(q, v.values, true, n, k)
+	q := v.env.values(k, n)
+	v.env.fourier(q, v.values, true, n, k)
 
 	// Divide by K, and untwist q to recover p.
-	u := make(fermat, n+1)
-	a := make([]nat, 1<<k)
+	a := v.env.nats(1 << k)
+	mark := v.env.mark()
+	shiftValues(a, q, -int(k), -θshift, fermat(v.env.nat(n+1)))
+	v.env.free(mark)
+	return poly{k: k, m: 0, a: a, env: v.env}
+}
+
+// shiftValues multiplies each q[i] by 2^(shift+i*step) in place,
+// and stores them as natural numbers in a. u is a temporary buffer.
+func shiftValues(a []nat, q []fermat, shift, step int, u fermat) {
 	for i := range q {
-		u.Shift(q[i], -int(k)-i*θshift)
+		u.Shift(q[i], shift+i*step)
 		copy(q[i], u)
 		a[i] = nat(q[i])
 	}
-	return poly{k: k, m: 0, a: a}
 }
 
 // fourier performs an unnormalized Fourier transform
 // of src, a length 1<<k vector of numbers modulo b^n+1
 // where b = 1<<_W.
 func fourier(dst []fermat, src []fermat, backward bool, n int, k uint) {
-	var rec func(dst, src []fermat, size uint)
-	tmp := make(fermat, n+1)  // pre-allocate temporary variables.
-	tmp2 := make(fermat, n+1) // pre-allocate temporary variables.
-
-	// The recursion function of the FFT.
-	// The root of unity used in the transform is ω=1<<(ω2shift/2).
-	// The source array may use shifted indices (i.e. the i-th
-	// element is src[i << idxShift]).
-	rec = func(dst, src []fermat, size uint) {
-		idxShift := k - size
-		ω2shift := (4 * n * _W) >> size
-		if backward {
-			ω2shift = -ω2shift
-		}
+	var e *env
+	e.fourier(dst, src, backward, n, k)
+}
 
-		// Easy cases.
-		if len(src[0]) != n+1 || len(dst[0]) != n+1 {
-			panic("len(src[0]) != n+1 || len(dst[0]) != n+1")
+// fourier is like the fourier function, but runs independent
+// parts of the transform in parallel if e allows it.
+func (e *env) fourier(dst []fermat, src []fermat, backward bool, n int, k uint) {
+	if t := e.config().FourStepThreshold; t > 0 && k >= 2 && (n+1)<<k >= t {
+		e.fourierFourStep(dst, src, backward, n, k)
+		return
+	}
+	mark := e.mark()
+	buf := fermat(e.nat(fourierBuf * (n + 1))) // pre-allocate temporary variables.
+	e.fourierRec(dst, src, backward, n, k, k, buf)
+	e.free(mark)
+}
+
+// fourierBuf is the number of temporary values
+// needed by fourierRec.
+const fourierBuf = 5
+
+// fourierRec is the recursion function of the FFT.
+// The root of unity used in the transform is ω=1<<(ω2shift/2).
+// The source array may use shifted indices (i.e. the i-th
+// element is src[i << idxShift]). buf is a temporary buffer
+// of fourierBuf values.
+//
+// The recursion only splits transforms to run their halves in
+// parallel: transforms computed by a single goroutine use the
+// iterative fourierIter.
+func (e *env) fourierRec(dst, src []fermat, backward bool, n int, k, size uint, buf fermat) {
+	idxShift := k - size
+	ω2shift := (4 * n * _W) >> size
+	if backward {
+		ω2shift = -ω2shift
+	}
+
+	// Easy cases.
+	if len(src[0]) != n+1 || len(dst[0]) != n+1 {
+		panic("len(src[0]) != n+1 || len(dst[0]) != n+1")
+	}
+	if (n+1)<<size >= cancelGrain && e.cancelled() {
+		return
+	}
+	if size < 2 || !e.parallel() || (n+1)<<size < 2*parallelGrain {
+		e.fourierIter(dst, src, backward, n, k, size, buf)
+		return
+	}
+
+	// Let P(x) = src[0] + src[1<<idxShift] * x + ... + src[K-1 << idxShift] * x^(K-1)
+	// The P(x) = Q1(x²) + x*Q2(x²)
+	// where Q1's coefficients are src with indices shifted by 1
+	// where Q2's coefficients are src[1<<idxShift:] with indices shifted by 1
+
+	// Split destination vectors in halves.
+	dst1 := dst[:1<<(size-1)]
+	dst2 := dst[1<<(size-1):]
+	// Transform Q1 and Q2 in the halves.
+	if e.acquire((n + 1) << (size - 1)) {
+		done := make(chan struct{})
+		go func() {
+			e.fourierRec(dst1, src, backward, n, k, size-1, make(fermat, fourierBuf*(n+1)))
+			e.release()
+			close(done)
+		}()
+		e.fourierRec(dst2, src[1<<idxShift:], backward, n, k, size-1, buf)
+		<-done
+	} else {
+		e.fourierRec(dst1, src, backward, n, k, size-1, buf)
+		e.fourierRec(dst2, src[1<<idxShift:], backward, n, k, size-1, buf)
+	}
+
+	// Reconstruct P's transform from transforms of Q1 and Q2.
+	// dst[i]            is dst1[i] + ω^i * dst2[i]
+	// dst[i + 1<<(k-1)] is dst1[i] + ω^(i+K/2) * dst2[i]
+	//
+	e.parallelRange(len(dst1), n+1, func(lo, hi int) {
+		butterflies(dst1[lo:hi], dst2[lo:hi], lo, ω2shift, make(fermat, n+1), make(fermat, n+1))
+	})
+}
+
+// fourierIter computes the same transform as fourierRec,
+// iteratively, using radix-4 steps.
+func (e *env) fourierIter(dst, src []fermat, backward bool, n int, k, size uint, buf fermat) {
+	idxShift := k - size
+	K := 1 << size
+	tb := buf[0*(n+1) : 1*(n+1)]
+	tc := buf[1*(n+1) : 2*(n+1)]
+	td := buf[2*(n+1) : 3*(n+1)]
+	tmp := buf[3*(n+1) : 4*(n+1)]
+	tmp2 := buf[4*(n+1) : 5*(n+1)]
+
+	// Put the coefficients in bit-reversed order, so that the
+	// transforms of subsequences are contiguous. If size is odd,
+	// start with transforms of length 2.
+	L := 1
+	if size%2 == 1 {
+		for i := 0; i < K; i += 2 {
+			r := rev(i, size)
+			x, y := src[r<<idxShift], src[(r+K/2)<<idxShift]
+			dst[i].Add(x, y)
+			dst[i+1].Sub(x, y)
 		}
-		switch size {
-		case 0:
-			copy(dst[0], src[0])
-			return
-		case 1:
-			dst[0].Add(src[0], src[1<<idxShift]) // dst[0] = src[0] + src[1]
-			dst[1].Sub(src[0], src[1<<idxShift]) // dst[1] = src[0] - src[1]
+		L = 2
+	} else {
+		for i := range dst[:K] {
+			copy(dst[i], src[rev(i, size)<<idxShift])
+		}
+	}
+
+	// The fourth root of unity ω^(K/4) is 2^(N/2), or its inverse.
+	iShift := n * _W / 2
+	if backward {
+		iShift = -iShift
+	}
+	for ; L < K; L *= 4 {
+		if (n+1)<<size >= cancelGrain && e.cancelled() {
 			return
 		}
+		// Each block of 4L values holds the transforms A, B, C, D of
+		// the subsequences of indices 0, 2, 1, 3 modulo 4. The transform
+		// of the block is A + ω^2j·B + ω^j·C + ω^3j·D at index j,
+		// where ω^L is a fourth root of unity:
+		//   y[j]    = (A + ω^2j·B) + (ω^j·C + ω^3j·D)
+		//   y[j+L]  = (A - ω^2j·B) + ω^L·(ω^j·C - ω^3j·D)
+		//   y[j+2L] = (A + ω^2j·B) - (ω^j·C + ω^3j·D)
+		//   y[j+3L] = (A - ω^2j·B) - ω^L·(ω^j·C - ω^3j·D)
+		ω2shift := (4 * n * _W) / (4 * L)
+		if backward {
+			ω2shift = -ω2shift
+		}
+		for base := 0; base < K; base += 4 * L {
+			for j := 0; j < L; j++ {
+				a, b := dst[base+j], dst[base+L+j]
+				c, d := dst[base+2*L+j], dst[base+3*L+j]
+				x, y := c, d
+				if j == 0 {
+					copy(tb, b)
+				} else {
+					tb.ShiftHalf(b, 2*j*ω2shift, tmp2)
+					tc.ShiftHalf(c, j*ω2shift, tmp2)
+					td.ShiftHalf(d, 3*j*ω2shift, tmp2)
+					x, y = tc, td
+				}
+				tmp.Sub(x, y)
+				tc.Add(x, y)
+				td.Shift(tmp, iShift)
+				b.Sub(a, tb)
+				a.Add(a, tb)
+				c.Sub(a, tc)
+				a.Add(a, tc)
+				d.Sub(b, td)
+				b.Add(b, td)
+			}
+		}
+	}
+}
 
-		// Let P(x) = src[0] + src[1<<idxShift] * x + ... + src[K-1 << idxShift] * x^(K-1)
-		// The P(x) = Q1(x²) + x*Q2(x²)
-		// where Q1's coefficients are src with indices shifted by 1
-		// where Q2's coefficients are src[1<<idxShift:] with indices shifted by 1
+// butterflies computes dst1[i] ± ω^(start+i) * dst2[i] in place,
+// where ω=1<<(ω2shift/2).
+func butterflies(dst1, dst2 []fermat, start, ω2shift int, tmp, tmp2 fermat) {
+	for i := range dst1 {
+		tmp.ShiftHalf(dst2[i], (start+i)*ω2shift, tmp2) // ω^i * dst2[i]
+		dst2[i].Sub(dst1[i], tmp)
+		dst1[i].Add(dst1[i], tmp)
+	}
+}
 
-		// Split destination vectors in halves.
-		dst1 := dst[:1<<(size-1)]
-		dst2 := dst[1<<(size-1):]
-		// Transform Q1 and Q2 in the halves.
-		rec(dst1, src, size-1)
-		rec(dst2, src[1<<idxShift:], size-1)
+// Mul returns the pointwise product of p and q.
+func (p *polValues) Mul(q *polValues) (r polValues) {
+	defer p.env.lap(p.env.clock(), stagePointwise)
+	n := p.n
+	r.k, r.n, r.env = p.k, p.n, p.env
+	r.values = p.env.vector(len(p.values), n)
+	if e := p.env; e.parallel() {
+		// The closure only captures slices, so that
+		// p, q and r do not escape in the serial case.
+		rv, pv, qv := r.values, p.values, q.values
+		sub := e.recursive(n, false)
+		e.parallelRange(len(rv), 4*n, func(lo, hi int) {
+			e.mulValues(rv[lo:hi], pv[lo:hi], qv[lo:hi], make(fermat, 8*n), sub)
+		})
+		e.merge(sub)
+	} else {
+		mark := p.env.mark()
+		sub := p.env.recursive(n, true)
+		p.env.mulValues(r.values, p.values, q.values, fermat(p.env.nat(8*n)), sub)
+		p.env.merge(sub)
+		p.env.free(mark)
+	}
+	return
+}
 
-		// Reconstruct P's transform from transforms of Q1 and Q2.
-		// dst[i]            is dst1[i] + ω^i * dst2[i]
-		// dst[i + 1<<(k-1)] is dst1[i] + ω^(i+K/2) * dst2[i]
-		//
-		for i := range dst1 {
-			tmp.ShiftHalf(dst2[i], i*ω2shift, tmp2) // ω^i * dst2[i]
-			dst2[i].Sub(dst1[i], tmp)
-			dst1[i].Add(dst1[i], tmp)
+// mulValues sets r[i] to p[i]*q[i], or p[i]² if q is nil.
+// buf is a temporary buffer of 8n words. If sub is not nil,
+// products are computed by negacyclic convolution in sub.
+func (e *env) mulValues(r, p, q []fermat, buf fermat, sub *env) {
+	for i := range r {
+		if e.cancelled() {
+			return
+		}
+		if sub != nil {
+			var qi fermat
+			if q != nil {
+				qi = q[i]
+			}
+			sub.mulNegacyclic(r[i], p[i], qi)
+			continue
 		}
+		var z fermat
+		if q == nil {
+			z = buf.Sqr(p[i])
+		} else {
+			z = buf.Mul(p[i], q[i])
+		}
+		copy(r[i], z)
 	}
-	rec(dst, src, k)
 }
 
-// Mul returns the pointwise product of p and q.
-func (p *polValues) Mul(q *polValues) (r polValues) {
+// Sqr returns the pointwise square of p.
+func (p *polValues) Sqr() (r polValues) {
+	defer p.env.lap(p.env.clock(), stagePointwise)
 	n := p.n
-	r.k, r.n = p.k, p.n
-	r.values = make([]fermat, len(p.values))
-	bits := make([]big.Word, len(p.values)*(n+1))
-	buf := make(fermat, 8*n)
-	for i := range r.values {
-		r.values[i] = bits[i*(n+1) : (i+1)*(n+1)]
-		z := buf.Mul(p.values[i], q.values[i])
-		copy(r.values[i], z)
+	r.k, r.n, r.env = p.k, p.n, p.env
+	r.values = p.env.vector(len(p.values), n)
+	if e := p.env; e.parallel() {
+		// The closure only captures slices, so that
+		// p, q and r do not escape in the serial case.
+		rv, pv := r.values, p.values
+		sub := e.recursive(n, false)
+		e.parallelRange(len(rv), 4*n, func(lo, hi int) {
+			e.mulValues(rv[lo:hi], pv[lo:hi], nil, make(fermat, 8*n), sub)
+		})
+		e.merge(sub)
+	} else {
+		mark := p.env.mark()
+		sub := p.env.recursive(n, true)
+		p.env.mulValues(r.values, p.values, nil, fermat(p.env.nat(8*n)), sub)
+		p.env.merge(sub)
+		p.env.free(mark)
 	}
 	return
 }
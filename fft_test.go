@@ -1,10 +1,12 @@
 package bigfft
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"math/rand"
 	"testing"
+	"time"
 )
 
 func cmpnat(t *testing.T, x, y nat) int {
@@ -166,6 +168,32 @@ func TestFourier(t *testing.T) {
 	}
 }
 
+func TestFourierParallel(t *testing.T) {
+	const N, k = 200, 8
+	src := make([]fermat, 1<<k)
+	for i := range src {
+		src[i] = make(fermat, N+1)
+		for p := 0; p < N; p++ {
+			src[i][p] = Word(rnd.Int63())
+		}
+	}
+	for _, backward := range []bool{false, true} {
+		dst1 := make([]fermat, 1<<k)
+		dst2 := make([]fermat, 1<<k)
+		for i := range src {
+			dst1[i] = make(fermat, N+1)
+			dst2[i] = make(fermat, N+1)
+		}
+		fourier(dst1, src, backward, N, k)
+		newEnv(4).fourier(dst2, src, backward, N, k)
+		for i := range src {
+			if cmpnat(t, nat(dst1[i]), nat(dst2[i])) != 0 {
+				t.Errorf("difference in dst[%d] (backward=%v)", i, backward)
+			}
+		}
+	}
+}
+
 // Tests Fourier transform and its reciprocal.
 func TestRoundTripPolyValues(t *testing.T) {
 	Size := 100000
@@ -231,6 +259,160 @@ func TestMul(t *testing.T) {
 	}
 }
 
+func TestMulParallel(t *testing.T) {
+	sizes := []int{1e3, 200e3, 2e6, 10e6}
+	var x, y Int
+	for _, size := range sizes {
+		x.SetBits(rndNat(size / _W))
+		y.SetBits(rndNat(size / _W))
+		y.Neg(&y)
+		z := new(Int).Mul(&x, &y)
+		for _, procs := range []int{0, 1, 2, 3, 8} {
+			z2 := MulParallel(&x, &y, procs)
+			if z.Cmp(z2) != 0 {
+				t.Errorf("incorrect product for size %d with %d goroutines", size, procs)
+			}
+		}
+	}
+}
+
+func TestMulContext(t *testing.T) {
+	var x, y Int
+	for _, size := range []int{1e3, 200e3, 2e6} {
+		x.SetBits(rndNat(size / _W))
+		y.SetBits(rndNat(size / _W))
+		z, err := MulContext(context.Background(), &x, &y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z.Cmp(new(Int).Mul(&x, &y)) != 0 {
+			t.Errorf("incorrect product for size %d", size)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := MulContext(ctx, &x, &y); err != context.Canceled {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+
+	// Abort a large multiplication.
+	x.SetBits(rndNat(50e6 / _W))
+	y.SetBits(rndNat(50e6 / _W))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	z, err := MulContext(ctx, &x, &y)
+	if err != context.DeadlineExceeded || z != nil {
+		t.Errorf("got (%v, %v), expected (nil, %v)", z, err, context.DeadlineExceeded)
+	}
+	t.Logf("multiplication aborted after %s", time.Since(t0))
+}
+
+func TestMulTo(t *testing.T) {
+	sizes := []int{1e3, 70e3, 200e3, 500e3}
+	for _, size1 := range sizes {
+		for _, size2 := range sizes {
+			x := new(Int).SetBits(rndNat(size1 / _W))
+			y := new(Int).SetBits(rndNat(size2 / _W))
+			y.Neg(y)
+			want := new(Int).Mul(x, y)
+
+			// Reuse the storage of a large enough z.
+			z := new(Int).SetBits(rndNat(len(want.Bits()) + 10))
+			buf := &z.Bits()[0]
+			if MulTo(z, x, y).Cmp(want) != 0 {
+				t.Errorf("MulTo(z, x, y) is incorrect for sizes %d, %d", size1, size2)
+			}
+			if &z.Bits()[0] != buf {
+				t.Errorf("MulTo(z, x, y) did not reuse storage of z for sizes %d, %d", size1, size2)
+			}
+
+			// Aliased arguments.
+			x2 := new(Int).Set(x)
+			if MulTo(x2, x2, y).Cmp(want) != 0 {
+				t.Errorf("MulTo(x, x, y) is incorrect for sizes %d, %d", size1, size2)
+			}
+			y2 := new(Int).Set(y)
+			if MulTo(y2, x, y2).Cmp(want) != 0 {
+				t.Errorf("MulTo(y, x, y) is incorrect for sizes %d, %d", size1, size2)
+			}
+		}
+		x := new(Int).SetBits(rndNat(size1 / _W))
+		want := new(Int).Mul(x, x)
+		if MulTo(x, x, x).Cmp(want) != 0 {
+			t.Errorf("MulTo(x, x, x) is incorrect for size %d", size1)
+		}
+	}
+}
+
+func TestMulUnbalanced(t *testing.T) {
+	type sizes struct{ x, y int }
+	tests := []sizes{
+		{1e6, 150e3},
+		{2e6, 120e3},
+		{5e6, 200e3},
+		{5e6, 500e3},
+	}
+	var x, y Int
+	for _, s := range tests {
+		x.SetBits(rndNat(s.x / _W))
+		y.SetBits(rndNat(s.y / _W))
+		want := new(Int).Mul(&x, &y)
+		z := new(Int).SetBits(fftmulUnbalanced(nil, nil, x.Bits(), y.Bits()))
+		if z.Cmp(want) != 0 {
+			t.Errorf("incorrect product for sizes %d, %d", s.x, s.y)
+		}
+		if z := Mul(&y, &x); z.Cmp(want) != 0 {
+			t.Errorf("incorrect Mul for sizes %d, %d", s.y, s.x)
+		}
+		x2 := new(Int).Set(&x)
+		if z := MulTo(x2, x2, &y); z.Cmp(want) != 0 {
+			t.Errorf("incorrect MulTo(x, x, y) for sizes %d, %d", s.x, s.y)
+		}
+	}
+
+	// The shorter operand may be below Threshold
+	// if blocks of the longer one are above.
+	th := DefaultConfig().Threshold
+	for _, s := range []sizes{{40 * th * _W, th / 2 * _W}, {40 * th * _W, th / 10 * _W}} {
+		x.SetBits(rndNat(s.x / _W))
+		y.SetBits(rndNat(s.y / _W))
+		want := new(Int).Mul(&x, &y)
+		z, stats := MulWithStats(&x, &y)
+		if z.Cmp(want) != 0 {
+			t.Errorf("incorrect Mul for sizes %d, %d", s.x, s.y)
+		}
+		if fft := unbalancedBlock*len(y.Bits()) > th; stats.FFT != fft || fft && stats.Blocks < 2 {
+			t.Errorf("sizes %d, %d: FFT is %v with %d blocks", s.x, s.y, stats.FFT, stats.Blocks)
+		}
+	}
+}
+
+func TestSqr(t *testing.T) {
+	sizes := []int{1e3, 5e3, 15e3, 25e3, 70e3, 200e3, 500e3, 2e6}
+	iters := 10
+	if testing.Short() {
+		iters = 1
+	}
+
+	var x Int
+	for i := 0; i < iters; i++ {
+		for _, size := range sizes {
+			x.SetBits(rndNat(size / _W))
+			if i%2 == 1 {
+				x.Neg(&x)
+			}
+			z := new(Int).Mul(&x, &x)
+			z2 := Sqr(&x)
+			if z.Cmp(z2) != 0 {
+				t.Errorf("z (%d bits) != z2 (%d bits)", z.BitLen(), z2.BitLen())
+				logbig(t, new(Int).Xor(z, z2))
+			}
+		}
+	}
+}
+
 func logbig(t *testing.T, n *Int) {
 	s := fmt.Sprintf("%x", n)
 	for len(s) > 64 {
@@ -319,6 +501,46 @@ func BenchmarkMul_20Mb(b *testing.B)  { benchmarkMul(b, 20e6, 20e6) }
 func BenchmarkMul_50Mb(b *testing.B)  { benchmarkMul(b, 50e6, 50e6) }
 func BenchmarkMul_100Mb(b *testing.B) { benchmarkMul(b, 100e6, 100e6) }
 
+func benchmarkMulTo(b *testing.B, sizex, sizey int) {
+	var x, y, z Int
+	x.SetBits(rndNat(sizex / _W))
+	y.SetBits(rndNat(sizey / _W))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MulTo(&z, &x, &y)
+	}
+}
+
+func benchmarkMulParallel(b *testing.B, size, procs int) {
+	var x, y Int
+	x.SetBits(rndNat(size / _W))
+	y.SetBits(rndNat(size / _W))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = MulParallel(&x, &y, procs)
+	}
+}
+
+func BenchmarkMulParallel_10Mb_4(b *testing.B)  { benchmarkMulParallel(b, 10e6, 4) }
+func BenchmarkMulParallel_100Mb_4(b *testing.B) { benchmarkMulParallel(b, 100e6, 4) }
+
+func BenchmarkMulTo_1Mb(b *testing.B)  { benchmarkMulTo(b, 1e6, 1e6) }
+func BenchmarkMulTo_10Mb(b *testing.B) { benchmarkMulTo(b, 10e6, 10e6) }
+
+func benchmarkSqr(b *testing.B, size int) {
+	xb := rndNat(size / _W)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var x Int
+		x.SetBits(xb)
+		_ = Sqr(&x)
+	}
+}
+
+func BenchmarkSqr_1Mb(b *testing.B)   { benchmarkSqr(b, 1e6) }
+func BenchmarkSqr_10Mb(b *testing.B)  { benchmarkSqr(b, 10e6) }
+func BenchmarkSqr_100Mb(b *testing.B) { benchmarkSqr(b, 100e6) }
+
 // Unbalanced multiplication benchmarks
 func BenchmarkMul_1x5Mb(b *testing.B)  { benchmarkMul(b, 1e6, 5e6) }
 func BenchmarkMul_1x10Mb(b *testing.B) { benchmarkMul(b, 1e6, 10e6) }
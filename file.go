@@ -0,0 +1,484 @@
+package bigfft
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+)
+
+// Out-of-core multiplication.
+//
+// The transforms of the operands are stored in temporary files.
+// They are computed in four steps (see fourierFourStep), so that
+// each step only needs a group of rows or columns in memory, and
+// each group is read or written with a few large accesses. The
+// transform of x is read in place of its input by the pointwise
+// product, and the inverse transform is written in the file of
+// the transform of y. Coefficients of the product are then added
+// sequentially to the result.
+
+// MulFile computes the product of the natural numbers stored in the
+// files x and y, and writes it to the file z, which must be distinct
+// from x and y. Numbers are stored as sequences of bytes of any length,
+// in little-endian order.
+//
+// Transforms are stored in temporary files created in dir, or in the
+// default directory for temporary files if dir is empty, and need about
+// 4 times the size of the product on disk. MulFile allocates about memory
+// bytes for buffers: operands whose product needs less memory than that
+// are multiplied in memory. Transforms need at least two rows of about
+// the square root of their length in memory, and smaller amounts of
+// memory are rounded up to that.
+func MulFile(z, x, y *os.File, dir string, memory int64) error {
+	return mulFile(defaultMultiplier.env(), z, x, y, dir, memory)
+}
+
+// ioBuffer is the maximal size (in bytes) of the buffer
+// used to read and write files.
+const ioBuffer = 1 << 20
+
+// mulFile is like MulFile, allocating buffers in e.
+func mulFile(e *env, z, x, y *os.File, dir string, memory int64) error {
+	xsize, err := fileSize(x)
+	if err != nil {
+		return err
+	}
+	ysize, err := fileSize(y)
+	if err != nil {
+		return err
+	}
+	if err := z.Truncate(0); err != nil {
+		return err
+	}
+	xwords := int((xsize + wordBytes - 1) / wordBytes)
+	ywords := int((ysize + wordBytes - 1) / wordBytes)
+	// Files are read and written through a buffer of
+	// a small part of memory.
+	bufSize := memory / 16
+	if bufSize > ioBuffer {
+		bufSize = ioBuffer
+	}
+	bufSize -= bufSize % wordBytes
+	if bufSize < wordBytes {
+		bufSize = wordBytes
+	}
+	buf := make([]byte, bufSize)
+	e.allocated(bufSize)
+	c := e.config()
+	m := NewMultiplier(*c)
+	// In memory, the operands are read in buffers before
+	// being multiplied.
+	if inMemory := c.mulMemory(xwords, ywords) + int64(xwords+ywords)*wordBytes + bufSize; !m.useTransform(xwords, ywords) || inMemory <= memory {
+		return mulFileInMemory(e, z, x, y, xsize, ysize, buf)
+	}
+
+	k, cm := c.fftSize(xwords + ywords)
+	n := valueSize(k, cm, 2)
+	// The rest of memory holds temporary values of transforms
+	// and of pointwise products, and a vector of values read
+	// from the files. Pointwise products of large values, which
+	// are computed recursively, allocate buffers of similar size.
+	tmp := fermat(e.nat(8 * (n + 1)))
+	count := (memory - bufSize - int64(len(tmp))*wordBytes) / (int64(n+1)*wordBytes + headerBytes)
+	if min := int64(2) << (k - k/2); count < min {
+		count = min
+	}
+	w := &fileWork{vals: e.vector(int(count), n), tmp: tmp, buf: buf}
+	xv, err := newFileVector(dir, k, n, w)
+	if err != nil {
+		return err
+	}
+	defer xv.remove()
+	yv, err := newFileVector(dir, k, n, w)
+	if err != nil {
+		return err
+	}
+	defer yv.remove()
+
+	if err := xv.transform(chunkLoader(x, xsize, cm, buf), false); err != nil {
+		return err
+	}
+	if err := yv.transform(chunkLoader(y, ysize, cm, buf), false); err != nil {
+		return err
+	}
+	if err := xv.mul(yv); err != nil {
+		return err
+	}
+	if err := yv.transform(xv.read, true); err != nil {
+		return err
+	}
+	return yv.intTo(z, cm, xwords+ywords)
+}
+
+// mulFileInMemory computes the product of the numbers
+// stored in x and y in memory and writes it to z.
+func mulFileInMemory(e *env, z, x, y *os.File, xsize, ysize int64, buf []byte) error {
+	var xi, yi big.Int
+	for _, v := range []struct {
+		f    *os.File
+		size int64
+		i    *big.Int
+	}{{x, xsize, &xi}, {y, ysize, &yi}} {
+		w := e.nat(int((v.size + wordBytes - 1) / wordBytes))
+		if err := readWords(v.f, v.size, 0, w, buf); err != nil {
+			return err
+		}
+		v.i.SetBits(trim(w))
+	}
+	m := NewMultiplier(*e.config())
+	var zi big.Int
+	if m.useTransform(len(xi.Bits()), len(yi.Bits())) {
+		mulFFTTo(e, &zi, &xi, &yi)
+	} else {
+		zi.Mul(&xi, &yi)
+	}
+	zb := zi.Bits()
+	if err := writeWords(z, 0, zb, buf); err != nil {
+		return err
+	}
+	return z.Truncate(byteLen(zb))
+}
+
+// byteLen returns the number of significant bytes of w.
+func byteLen(w nat) int64 {
+	var x big.Int
+	return int64(x.SetBits(w).BitLen()+7) / 8
+}
+
+func fileSize(f *os.File) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+// A loader reads the consecutive elements of a vector
+// starting at index i into the values of dst.
+type loader func(i int, dst []fermat) error
+
+// chunkLoader returns a loader reading the chunks of m words
+// of the number stored in f, of the given size in bytes, through
+// buf. The values it reads must be contiguous, like those
+// allocated by env.vector.
+func chunkLoader(f io.ReaderAt, size int64, m int, buf []byte) loader {
+	return func(i int, dst []fermat) error {
+		w := nat(dst[0][: len(dst)*m : len(dst)*m])
+		if err := readWords(f, size, int64(i)*int64(m), w, buf); err != nil {
+			return err
+		}
+		// Spread the chunks in the values, from the last one
+		// since they are read in the storage of the first ones.
+		for j := len(dst) - 1; j >= 0; j-- {
+			v := dst[j]
+			copy(v, w[j*m:(j+1)*m])
+			for l := m; l < len(v); l++ {
+				v[l] = 0
+			}
+		}
+		return nil
+	}
+}
+
+// A fileWork holds the buffers shared by the steps of
+// an out-of-core multiplication.
+type fileWork struct {
+	vals []fermat // contiguous values read from files.
+	tmp  fermat   // temporary values, of 8(n+1) words.
+	buf  []byte   // buffer for reads and writes.
+}
+
+// A fileVector is a vector of 1<<k values of n+1 words
+// stored in a temporary file.
+type fileVector struct {
+	f *os.File
+	k uint
+	n int
+	w *fileWork
+}
+
+func newFileVector(dir string, k uint, n int, w *fileWork) (*fileVector, error) {
+	f, err := ioutil.TempFile(dir, "bigfft")
+	if err != nil {
+		return nil, err
+	}
+	return &fileVector{f: f, k: k, n: n, w: w}, nil
+}
+
+// remove closes and removes the file of v.
+func (v *fileVector) remove() {
+	v.f.Close()
+	os.Remove(v.f.Name())
+}
+
+// read reads the values of v starting at index i into dst,
+// which must be contiguous, like those allocated by env.vector.
+func (v *fileVector) read(i int, dst []fermat) error {
+	w := nat(dst[0][: len(dst)*(v.n+1) : len(dst)*(v.n+1)])
+	// All values are written before being read.
+	return readWords(v.f, math.MaxInt64, int64(i)*int64(v.n+1), w, v.w.buf)
+}
+
+// write writes the values of src at index i,
+// which must be contiguous, like those allocated by env.vector.
+func (v *fileVector) write(i int, src []fermat) error {
+	w := nat(src[0][: len(src)*(v.n+1) : len(src)*(v.n+1)])
+	return writeWords(v.f, int64(i)*int64(v.n+1), w, v.w.buf)
+}
+
+// group returns the largest power of two k such that two vectors
+// of 1<<k rows of 1<<size values fit in memory.
+func (v *fileVector) group(size uint) uint {
+	g := uint(0)
+	for g+size < v.k && 2<<(g+1+size) <= len(v.w.vals) {
+		g++
+	}
+	return g
+}
+
+// transform computes the Fourier transform of the vector
+// given by load, or its inverse transform without the division
+// by K, and writes it in v.
+func (v *fileVector) transform(load loader, backward bool) error {
+	k := v.k
+	k1 := k / 2
+	k2 := k - k1
+	K1, K2 := 1<<k1, 1<<k2
+	n := v.n
+	ω2shift := (4 * n * _W) >> k
+	if backward {
+		ω2shift = -ω2shift
+	}
+	var e *env
+	buf := v.w.tmp[:fourierBuf*(n+1)]
+	tmp, tmp2 := buf[:n+1], buf[n+1:2*(n+1)]
+
+	// Transform groups of G columns: the elements of column i1 are
+	// i1 + K1*i2. Rows i1 of the result are contiguous in v.
+	g := v.group(k2)
+	G := 1 << g
+	src := v.w.vals[:G<<k2]
+	dst := v.w.vals[G<<k2 : 2*G<<k2]
+	for lo := 0; lo < K1; lo += G {
+		for i2 := 0; i2 < K2; i2++ {
+			if err := load(lo+K1*i2, src[i2*G:(i2+1)*G]); err != nil {
+				return err
+			}
+		}
+		for c := 0; c < G; c++ {
+			row := dst[c*K2 : (c+1)*K2]
+			e.fourierRec(row, src[c:], backward, n, g+k2, k2, buf)
+			for j2 := 1; j2 < K2; j2++ {
+				tmp.ShiftHalf(row[j2], (lo+c)*j2*ω2shift, tmp2)
+				copy(row[j2], tmp)
+			}
+		}
+		if err := v.write(lo*K2, dst); err != nil {
+			return err
+		}
+	}
+
+	// Transform groups of G rows in place: the elements of row j2
+	// are j2 + K2*i1, and the result is j2 + K2*j1.
+	g = v.group(k1)
+	G = 1 << g
+	vals := v.w.vals[:G<<k1]
+	col := v.w.vals[G<<k1 : G<<k1+K1]
+	for lo := 0; lo < K2; lo += G {
+		for i1 := 0; i1 < K1; i1++ {
+			if err := v.read(i1*K2+lo, vals[i1*G:(i1+1)*G]); err != nil {
+				return err
+			}
+		}
+		for c := 0; c < G; c++ {
+			e.fourierRec(col, vals[c:], backward, n, g+k1, k1, buf)
+			for j1, x := range col {
+				copy(vals[j1*G+c], x)
+			}
+		}
+		for j1 := 0; j1 < K1; j1++ {
+			if err := v.write(j1*K2+lo, vals[j1*G:(j1+1)*G]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mul multiplies the values of v by the values of w, in place.
+func (v *fileVector) mul(w *fileVector) error {
+	K := 1 << v.k
+	count := len(v.w.vals) / 2
+	if count > K {
+		count = K
+	}
+	var e *env
+	x := v.w.vals[:count]
+	y := v.w.vals[count : 2*count]
+	sub := e.recursive(v.n, false)
+	for i := 0; i < K; i += count {
+		if i+count > K {
+			count = K - i
+		}
+		if err := v.read(i, x[:count]); err != nil {
+			return err
+		}
+		if err := w.read(i, y[:count]); err != nil {
+			return err
+		}
+		e.mulValues(x[:count], x[:count], y[:count], v.w.tmp[:8*v.n], sub)
+		if err := v.write(i, x[:count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// intTo divides the values of v by K and writes their value
+// at b^m to z. The result has at most length words.
+func (v *fileVector) intTo(z *os.File, m, length int) error {
+	K := 1 << v.k
+	n := v.n
+	// Values are read in the first half of the buffer, and
+	// the words of the result are written from the second.
+	count := len(v.w.vals) / 2
+	if count > K {
+		count = K
+	}
+	vals := v.w.vals[:count]
+	u := v.w.tmp[:n+1]
+	// acc holds the words of the result from offset pos,
+	// to which later coefficients are added.
+	acc := nat(v.w.tmp[n+1 : 2*n+3])
+	for i := range acc {
+		acc[i] = 0
+	}
+	out := nat(v.w.vals[count][: 0 : count*(n+1)])
+	pos := 0
+	written := 0
+	end := int64(0) // the size of z in bytes.
+	flush := func() error {
+		if written+len(out) > length {
+			out = out[:length-written]
+		}
+		if err := writeWords(z, int64(written), out, v.w.buf); err != nil {
+			return err
+		}
+		if t := trim(out); len(t) > 0 {
+			end = int64(written)*wordBytes + byteLen(t)
+		}
+		written += len(out)
+		out = out[:0]
+		return nil
+	}
+	for i := 0; i < K && pos < length; i += count {
+		if i+count > K {
+			count = K - i
+		}
+		if err := v.read(i, vals[:count]); err != nil {
+			return err
+		}
+		for _, c := range vals[:count] {
+			u.Shift(c, -int(v.k))
+			if carry := addVV(acc[:n+1], acc[:n+1], nat(u)); carry != 0 {
+				acc[n+1] += carry
+			}
+			// The first m words are final.
+			out = append(out, acc[:m]...)
+			copy(acc, acc[m:])
+			for j := len(acc) - m; j < len(acc); j++ {
+				acc[j] = 0
+			}
+			pos += m
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	out = acc
+	if err := flush(); err != nil {
+		return err
+	}
+	// Remove high zero bytes.
+	return z.Truncate(end)
+}
+
+// readWords reads len(w) words, in little-endian order, at the word
+// offset off of f, of the given size in bytes. Words after the end
+// of f are zero. buf is a buffer of at least one word.
+func readWords(f io.ReaderAt, size, off int64, w nat, buf []byte) error {
+	for len(w) > 0 {
+		l := len(buf) / int(wordBytes)
+		if l > len(w) {
+			l = len(w)
+		}
+		if err := readBuffer(f, size, off, w[:l], buf); err != nil {
+			return err
+		}
+		w = w[l:]
+		off += int64(l)
+	}
+	return nil
+}
+
+// readBuffer reads the words of w, which fit in buf, like readWords.
+func readBuffer(f io.ReaderAt, size, off int64, w nat, buf []byte) error {
+	start := off * wordBytes
+	b := buf[:int64(len(w))*wordBytes]
+	avail := size - start
+	if avail < 0 {
+		avail = 0
+	}
+	if avail > int64(len(b)) {
+		avail = int64(len(b))
+	}
+	if avail > 0 {
+		if n, err := f.ReadAt(b[:avail], start); n < int(avail) {
+			if err == io.EOF {
+				err = nil // the file is shorter than size.
+			}
+			if err != nil {
+				return err
+			}
+			avail = int64(n)
+		}
+	}
+	for i := avail; i < int64(len(b)); i++ {
+		b[i] = 0
+	}
+	for i := range w {
+		var x big.Word
+		for j := int(wordBytes) - 1; j >= 0; j-- {
+			x = x<<8 | big.Word(b[i*int(wordBytes)+j])
+		}
+		w[i] = x
+	}
+	return nil
+}
+
+// writeWords writes the words of w, in little-endian order,
+// at the word offset off of f. buf is a buffer of at least
+// one word.
+func writeWords(f io.WriterAt, off int64, w nat, buf []byte) error {
+	for len(w) > 0 {
+		l := len(buf) / int(wordBytes)
+		if l > len(w) {
+			l = len(w)
+		}
+		b := buf[:int64(l)*wordBytes]
+		for i, x := range w[:l] {
+			for j := 0; j < int(wordBytes); j++ {
+				b[i*int(wordBytes)+j] = byte(x)
+				x >>= 8
+			}
+		}
+		if _, err := f.WriteAt(b, off*wordBytes); err != nil {
+			return err
+		}
+		w = w[l:]
+		off += int64(l)
+	}
+	return nil
+}
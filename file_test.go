@@ -0,0 +1,84 @@
+package bigfft
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMulFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bigfft-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	create := func(name string, b []byte) *os.File {
+		f, err := os.Create(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	littleEndian := func(x *Int) []byte {
+		b := x.Bytes()
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+		return b
+	}
+	tests := []struct {
+		xbits, ybits int
+		memory       int64
+	}{
+		{0, 1000, 1 << 20},
+		{1000, 3000, 1 << 20},
+		{500e3, 500e3, 1 << 30}, // in memory
+		{500e3, 500e3, 64 << 10},
+		{1e6 + 24, 300e3 + 8, 32 << 10},
+		{2e6 + 8, 2e6 + 16, 256 << 10},
+		{4e6, 200e3, 1 << 20},
+	}
+	for _, tt := range tests {
+		var x, y Int
+		x.SetBits(rndNat(tt.xbits / _W))
+		y.SetBits(rndNat(tt.ybits / _W))
+		// Sizes which are not a multiple of the word size.
+		x.Rsh(&x, 3)
+		xf := create("x", littleEndian(&x))
+		yf := create("y", littleEndian(&y))
+		zf := create("z", []byte("garbage"))
+		var stats Stats
+		e := defaultMultiplier.env()
+		e.stats = &stats
+		if err := mulFile(e, zf, xf, yf, dir, tt.memory); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Bytes > tt.memory {
+			t.Errorf("product of %d and %d bits allocated %d bytes, with %d bytes of memory",
+				tt.xbits, tt.ybits, stats.Bytes, tt.memory)
+		}
+		got, err := ioutil.ReadFile(zf.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := littleEndian(new(Int).Mul(&x, &y))
+		if string(got) != string(want) {
+			t.Errorf("incorrect product of %d and %d bits, with %d bytes of memory: got %d bytes, expected %d",
+				tt.xbits, tt.ybits, tt.memory, len(got), len(want))
+		}
+		for _, f := range []*os.File{xf, yf, zf} {
+			f.Close()
+		}
+	}
+	// Temporary files are removed.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("found %d files, expected x, y, z", len(files))
+	}
+}
@@ -0,0 +1,155 @@
+package bigfft
+
+import (
+	"math/big"
+	"sync"
+)
+
+// A FixedMultiplier computes products x*y where y is a fixed
+// number. The Fourier transform of y is computed once and reused
+// by all multiplications, so that each product only needs to
+// transform x.
+//
+// Transforms of y depend on the size of x: a FixedMultiplier keeps
+// those of the fixedCache sizes used most recently, and operands
+// of other sizes cause y to be transformed again. Operands much
+// longer than y are cut into blocks, like by Mul, which share
+// a transform of y sized for blocks.
+//
+// A FixedMultiplier is safe for concurrent use.
+type FixedMultiplier struct {
+	m *Multiplier
+	y *big.Int // a private copy of y.
+
+	mu    sync.Mutex
+	cache []*fixedValues // transforms of y, most recently used first.
+}
+
+// fixedCache is the maximal number of transforms
+// kept by a FixedMultiplier.
+const fixedCache = 4
+
+// NewFixedMultiplier returns a FixedMultiplier by y. The transform
+// of y is sized for operands of about xbits bits: larger operands
+// are supported but cause y to be transformed again.
+func NewFixedMultiplier(y *big.Int, xbits int) *FixedMultiplier {
+	return defaultMultiplier.NewFixedMultiplier(y, xbits)
+}
+
+// NewFixedMultiplier is like the NewFixedMultiplier function,
+// but uses the configuration of m.
+func (m *Multiplier) NewFixedMultiplier(y *big.Int, xbits int) *FixedMultiplier {
+	f := &FixedMultiplier{m: m, y: new(big.Int).Set(y)}
+	xwords := (xbits + _W - 1) / _W
+	if f.useFFT(xwords) {
+		f.values(f.transformSize(xwords))
+	}
+	return f
+}
+
+// useFFT reports whether products by numbers of xwords words
+// are computed by FFT.
+func (f *FixedMultiplier) useFFT(xwords int) bool {
+	return f.m.useFFT(xwords, len(f.y.Bits()))
+}
+
+// transformSize returns the size (in words) of the operands that
+// the transform of y must fit, to multiply numbers of xwords words:
+// the size of blocks if they are cut into blocks.
+func (f *FixedMultiplier) transformSize(xwords int) int {
+	ywords := len(f.y.Bits())
+	if xwords > ywords && f.m.cfg.unbalanced(xwords, ywords) {
+		return unbalancedBlock * ywords
+	}
+	return xwords
+}
+
+// Mul computes the product x*y and returns it.
+func (f *FixedMultiplier) Mul(x *big.Int) *big.Int {
+	xb := x.Bits()
+	if !f.useFFT(len(xb)) {
+		return new(big.Int).Mul(x, f.y)
+	}
+	var zb nat
+	if words := f.transformSize(len(xb)); words < len(xb) {
+		zb = f.values(words).mulBlocks(f.m.env(), nil, xb)
+	} else {
+		zb = f.values(words).mulTo(f.m.env(), nil, xb)
+	}
+	z := new(big.Int)
+	z.SetBits(zb)
+	if x.Sign()*f.y.Sign() < 0 {
+		z.Neg(z)
+	}
+	return z
+}
+
+// values returns a transform of y to compute products by numbers
+// of xwords words. The smallest cached transform is used if it is
+// large enough, and at most twice as long as needed.
+func (f *FixedMultiplier) values(xwords int) *fixedValues {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	yb := f.y.Bits()
+	k, m := f.m.cfg.fftSize(xwords + len(yb))
+	best := -1
+	for i, fy := range f.cache {
+		if !fy.fits(xwords) || fy.k > k+1 {
+			continue
+		}
+		if best < 0 || fy.smaller(f.cache[best]) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		fy := f.cache[best]
+		copy(f.cache[1:best+1], f.cache[:best])
+		f.cache[0] = fy
+		return fy
+	}
+	fy := newFixedValues(f.m.env(), yb, k, m)
+	if len(f.cache) < fixedCache {
+		f.cache = append(f.cache, nil)
+	}
+	copy(f.cache[1:], f.cache)
+	f.cache[0] = &fy
+	return &fy
+}
+
+// fixedValues holds the Fourier transform of a number y,
+// sliced in 1<<k coefficients of m words.
+type fixedValues struct {
+	k  uint
+	m  int
+	y  nat
+	yv polValues
+}
+
+// newFixedValues transforms y, allocating the transform in e.
+func newFixedValues(e *env, y nat, k uint, m int) fixedValues {
+	yp := e.polyFromNat(y, k, m)
+	n := valueSize(k, m, 2)
+	return fixedValues{k: k, m: m, y: y, yv: yp.Transform(n)}
+}
+
+// fits reports whether the product of y by a number of
+// xwords words can be computed by this transform.
+func (f *fixedValues) fits(xwords int) bool {
+	// The product polynomial has xwords/m + len(y)/m + 1
+	// coefficients and must not wrap around modulo X^K-1.
+	return xwords/f.m+len(f.y)/f.m+1 <= 1<<f.k
+}
+
+// smaller reports whether f has smaller values than g.
+func (f *fixedValues) smaller(g *fixedValues) bool {
+	return f.k < g.k || f.k == g.k && f.m < g.m
+}
+
+// mulTo computes x*y, where x must fit in the transform,
+// using the storage of z if it is large enough.
+func (f *fixedValues) mulTo(e *env, z, x nat) nat {
+	xp := e.polyFromNat(x, f.k, f.m)
+	xv := xp.Transform(f.yv.n)
+	rv := xv.Mul(&f.yv)
+	return rv.invTransformTo(z, f.m, len(x)+len(f.y), xv.values)
+}
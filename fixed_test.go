@@ -0,0 +1,97 @@
+package bigfft
+
+import (
+	"testing"
+)
+
+func TestFixedMultiplier(t *testing.T) {
+	sizes := []int{1e3, 70e3, 200e3, 500e3, 2e6}
+	for _, ysize := range sizes {
+		y := new(Int).SetBits(rndNat(ysize / _W))
+		y.Neg(y)
+		f := NewFixedMultiplier(y, 200e3)
+		fy := f.last()
+		for _, xsize := range sizes {
+			x := new(Int).SetBits(rndNat(xsize / _W))
+			want := new(Int).Mul(x, y)
+			if got := f.Mul(x); got.Cmp(want) != 0 {
+				t.Errorf("incorrect product for sizes %d, %d", xsize, ysize)
+			}
+			x.Neg(x)
+			want.Neg(want)
+			if got := f.Mul(x); got.Cmp(want) != 0 {
+				t.Errorf("incorrect product for sizes -%d, %d", xsize, ysize)
+			}
+			if xsize <= 200e3 && f.last() != fy {
+				t.Errorf("transform of y (%d bits) recomputed for x of %d bits", ysize, xsize)
+			}
+		}
+		// The transform for smaller operands is still cached. A transform
+		// for blocks of the same size may be used instead.
+		cached := append([]*fixedValues(nil), f.cache...)
+		x := new(Int).SetBits(rndNat(200e3 / _W))
+		if f.Mul(x).Cmp(new(Int).Mul(x, y)) != 0 {
+			t.Errorf("incorrect product for sizes 200e3, %d", ysize)
+		}
+		reused := false
+		for _, fv := range cached {
+			reused = reused || fv == f.last()
+		}
+		if fy != nil && (!reused || f.last().k != fy.k || f.last().m != fy.m) {
+			t.Errorf("transform of y (%d bits) for x of 200e3 bits is not reused", ysize)
+		}
+		if len(f.cache) > fixedCache {
+			t.Errorf("%d transforms of y are cached", len(f.cache))
+		}
+	}
+
+	// Products using a configuration.
+	m := NewMultiplier(Config{Threshold: 200, Parallelism: 3})
+	y := new(Int).SetBits(rndNat(100e3 / _W))
+	f := m.NewFixedMultiplier(y, 20e3)
+	for _, xsize := range []int{20e3, 100e3, 1e6, 20e3} {
+		x := new(Int).SetBits(rndNat(xsize / _W))
+		if f.Mul(x).Cmp(new(Int).Mul(x, y)) != 0 {
+			t.Errorf("incorrect product for sizes %d, 100e3 with threshold 200", xsize)
+		}
+	}
+	if len(f.cache) != 3 {
+		t.Errorf("got %d cached transforms of y, expected 3", len(f.cache))
+	}
+
+	// A short y multiplies blocks of long operands.
+	ywords := DefaultConfig().Threshold/unbalancedBlock + 100
+	y.SetBits(rndNat(ywords))
+	f = NewFixedMultiplier(y, 0)
+	for _, xwords := range []int{100 * ywords, 30 * ywords} {
+		x := new(Int).SetBits(rndNat(xwords))
+		if f.Mul(x).Cmp(new(Int).Mul(x, y)) != 0 {
+			t.Errorf("incorrect product for sizes %d, %d words", xwords, ywords)
+		}
+	}
+	if len(f.cache) != 1 || !f.last().fits(unbalancedBlock*ywords) || f.last().fits(30*ywords) {
+		t.Errorf("transforms of y of %d words are not sized for blocks", ywords)
+	}
+}
+
+// last returns the transform of y used by the last product.
+func (f *FixedMultiplier) last() *fixedValues {
+	if len(f.cache) == 0 {
+		return nil
+	}
+	return f.cache[0]
+}
+
+func benchmarkFixedMul(b *testing.B, sizex, sizey int) {
+	var x, y Int
+	x.SetBits(rndNat(sizex / _W))
+	y.SetBits(rndNat(sizey / _W))
+	f := NewFixedMultiplier(&y, sizex)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = f.Mul(&x)
+	}
+}
+
+func BenchmarkFixedMul_1Mb(b *testing.B)  { benchmarkFixedMul(b, 1e6, 1e6) }
+func BenchmarkFixedMul_10Mb(b *testing.B) { benchmarkFixedMul(b, 10e6, 10e6) }
@@ -0,0 +1,361 @@
+package bigfft
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Floating-point transforms.
+//
+// Below the threshold of FFT modulo 2^N+1, products can be computed
+// by a complex FFT in double precision. Operands are cut in limbs of
+// 16 bits, made balanced in [-2^15, 2^15) to halve the size of
+// coefficients, and the convolution of limbs is computed by two
+// forward transforms and an inverse transform of length N = 1<<k.
+//
+// Since limbs are real, their transform of length N is computed by a
+// complex transform of length N/2 of pairs of limbs, followed by a
+// step separating the transforms of even and odd limbs and combining
+// them with a last level of twiddle factors. The inverse transform
+// reverses these steps. Transforms use radix-4 butterflies, with a
+// radix-2 level if the number of levels is odd.
+//
+// The result is exact if the rounding error of each coefficient is
+// less than 1/2. Following Percival, "Rapid multiplication modulo the
+// sum and difference of highly composite numbers" (2003), the error of
+// transforms of length N is at most
+//
+//	|x| |y| ((1+ε)^(3k+3) (1+ε√5)^(3k+1) (1+β)^3k - 1)
+//
+// where |x| and |y| are the Euclidean norms of the vectors of limbs,
+// at most 2^15 √N, ε = 2^-53 and β is the error of the roots of unity,
+// at most 2ε when computed by math.Sincos. Pairing limbs keeps their
+// norm, and the transforms of length N/2 have k-1 levels: the steps
+// separating and joining the transforms of even and odd limbs are
+// counted as their last and first levels, since they multiply by a
+// root of unity like a butterfly. They also add c and the conjugate
+// of c2 before the butterfly, adding a factor (1+ε) for each of the
+// two operands and the product. The bound is:
+//
+//	k      10     11     12     13     14     15
+//	error  0.020  0.043  0.095  0.205  0.440  0.941
+//
+// so transforms are limited to length 1<<floatMaxK, products of
+// 2^14 limbs (4096 words on 64-bit platforms). As a safeguard, every
+// coefficient is checked to be within floatMargin of an integer,
+// and the product is computed by FFT modulo 2^N+1 if one is not.
+
+const (
+	// floatMaxK is the largest length 1<<floatMaxK of floating-point
+	// transforms, for which the rounding error is less than 1/2.
+	floatMaxK = 14
+	// floatLimbs is the number of 16-bit limbs of a word.
+	floatLimbs = _W / 16
+)
+
+// floatMargin is the largest distance of coefficients to an integer
+// accepted by floatmulTo. Tests change it to check the fallback.
+var floatMargin = 0.125
+
+var (
+	floatOnce   sync.Once
+	floatRoots  []complex128 // see floatRootsTo.
+	floatRoots3 []complex128
+)
+
+// floatRootsTo returns the tables of roots of unity for transforms of
+// length up to 1<<k: roots[h+j] = exp(-iπj/h) and roots3[h+j] =
+// exp(-3iπj/h) for j < h.
+func floatRootsTo(k uint) (roots, roots3 []complex128) {
+	floatOnce.Do(func() {
+		L := 1 << floatMaxK
+		floatRoots = make([]complex128, L)
+		floatRoots3 = make([]complex128, L)
+		for h := 1; h < L; h *= 2 {
+			for j := 0; j < h; j++ {
+				s, c := math.Sincos(-math.Pi * float64(j) / float64(h))
+				floatRoots[h+j] = complex(c, s)
+				s, c = math.Sincos(-3 * math.Pi * float64(j) / float64(h))
+				floatRoots3[h+j] = complex(c, s)
+			}
+		}
+	})
+	return floatRoots[:1<<k], floatRoots3[:1<<k]
+}
+
+// floatSize returns the length 1<<k of floating-point transforms to
+// multiply numbers of xwords and ywords words, and false if it is
+// larger than 1<<floatMaxK.
+func floatSize(xwords, ywords int) (k uint, ok bool) {
+	// The balanced limbs of x*y have a carry limb for each operand.
+	k = uint(bits.Len(uint((xwords + ywords) * floatLimbs)))
+	return k, k <= floatMaxK
+}
+
+// useFloat reports whether the product of numbers of xwords and ywords
+// words, below the threshold of FFT, is computed by floating-point
+// transforms.
+func (c *Config) useFloat(xwords, ywords int) bool {
+	if c.FloatThreshold < 0 || xwords <= c.FloatThreshold || ywords <= c.FloatThreshold {
+		return false
+	}
+	if xwords > c.Threshold && ywords > c.Threshold {
+		return false
+	}
+	_, ok := floatSize(xwords, ywords)
+	return ok
+}
+
+// floatLimbsOf sets a to the balanced limbs of x, followed by zeros,
+// two limbs per value: a[j] holds limbs 2j and 2j+1 in its real and
+// imaginary parts. a must be longer than the limbs of x, to hold the
+// last carry.
+func floatLimbsOf(a []complex128, x nat) {
+	var carry int64
+	var d [floatLimbs]float64
+	for i, w := range x {
+		for l := range d {
+			v := int64(uint16(w>>uint(l*16))) + carry
+			carry = 0
+			if v >= 1<<15 {
+				v -= 1 << 16
+				carry = 1
+			}
+			d[l] = float64(v)
+		}
+		for l := 0; l < floatLimbs; l += 2 {
+			a[(i*floatLimbs+l)/2] = complex(d[l], d[l+1])
+		}
+	}
+	a = a[len(x)*floatLimbs/2:]
+	for j := range a {
+		a[j] = 0
+	}
+	a[0] = complex(float64(carry), 0)
+}
+
+// mulConj returns a*conj(w).
+func mulConj(a, w complex128) complex128 {
+	return complex(real(a)*real(w)+imag(a)*imag(w), imag(a)*real(w)-real(a)*imag(w))
+}
+
+// mulI returns i*a.
+func mulI(a complex128) complex128 {
+	return complex(-imag(a), real(a))
+}
+
+// floatForward computes the transform of a in place, leaving its
+// values in bit-reversed order. roots and roots3 are the tables
+// returned by floatRootsTo.
+func floatForward(a, roots, roots3 []complex128) {
+	q := len(a) / 4
+	if bits.TrailingZeros(uint(len(a)))%2 == 1 {
+		// A radix-2 level.
+		h := len(a) / 2
+		x, y, w := a[:h], a[h:], roots[h:2*h]
+		y, w = y[:len(x)], w[:len(x)]
+		for j, u := range x {
+			v := y[j]
+			x[j] = u + v
+			y[j] = (u - v) * w[j]
+		}
+		q /= 2
+	}
+	for ; q > 1; q /= 4 {
+		w1, w2, w3 := roots[2*q:3*q], roots[q:2*q], roots3[2*q:3*q]
+		for i := 0; i < len(a); i += 4 * q {
+			x0, x1, x2, x3 := a[i:i+q], a[i+q:i+2*q], a[i+2*q:i+3*q], a[i+3*q:i+4*q]
+			x1, x2, x3 = x1[:len(x0)], x2[:len(x0)], x3[:len(x0)]
+			w1, w2, w3 := w1[:len(x0)], w2[:len(x0)], w3[:len(x0)]
+			for j, a0 := range x0 {
+				a1, a2, a3 := x1[j], x2[j], x3[j]
+				s02, d02 := a0+a2, a0-a2
+				s13, d13 := a1+a3, mulI(a1-a3)
+				x0[j] = s02 + s13
+				x1[j] = (s02 - s13) * w2[j]
+				x2[j] = (d02 - d13) * w1[j]
+				x3[j] = (d02 + d13) * w3[j]
+			}
+		}
+	}
+	if q == 1 {
+		// The last level has no twiddle factors.
+		for i := 0; i+3 < len(a); i += 4 {
+			a0, a1, a2, a3 := a[i], a[i+1], a[i+2], a[i+3]
+			s02, d02 := a0+a2, a0-a2
+			s13, d13 := a1+a3, mulI(a1-a3)
+			a[i], a[i+1] = s02+s13, s02-s13
+			a[i+2], a[i+3] = d02-d13, d02+d13
+		}
+	}
+}
+
+// floatInverse computes the unnormalized inverse transform of a in
+// place, where a is in bit-reversed order, as left by floatForward.
+func floatInverse(a, roots, roots3 []complex128) {
+	q := 1
+	if 4*q <= len(a) {
+		// The first level has no twiddle factors.
+		for i := 0; i+3 < len(a); i += 4 {
+			a0, a1, a2, a3 := a[i], a[i+1], a[i+2], a[i+3]
+			t0, t1 := a0+a1, a0-a1
+			u, v := a2+a3, mulI(a2-a3)
+			a[i], a[i+1] = t0+u, t1+v
+			a[i+2], a[i+3] = t0-u, t1-v
+		}
+		q = 4
+	}
+	for ; 4*q <= len(a); q *= 4 {
+		w1, w2, w3 := roots[2*q:3*q], roots[q:2*q], roots3[2*q:3*q]
+		for i := 0; i < len(a); i += 4 * q {
+			x0, x1, x2, x3 := a[i:i+q], a[i+q:i+2*q], a[i+2*q:i+3*q], a[i+3*q:i+4*q]
+			x1, x2, x3 = x1[:len(x0)], x2[:len(x0)], x3[:len(x0)]
+			w1, w2, w3 := w1[:len(x0)], w2[:len(x0)], w3[:len(x0)]
+			for j, a0 := range x0 {
+				a1 := mulConj(x1[j], w2[j])
+				a2 := mulConj(x2[j], w1[j])
+				a3 := mulConj(x3[j], w3[j])
+				t0, t1 := a0+a1, a0-a1
+				u, v := a2+a3, mulI(a2-a3)
+				x0[j] = t0 + u
+				x1[j] = t1 + v
+				x2[j] = t0 - u
+				x3[j] = t1 - v
+			}
+		}
+	}
+	if q < len(a) {
+		// A radix-2 level.
+		h := len(a) / 2
+		x, y, w := a[:h], a[h:], roots[h:2*h]
+		y, w = y[:len(x)], w[:len(x)]
+		for j, u := range x {
+			v := mulConj(y[j], w[j])
+			x[j] = u + v
+			y[j] = u - v
+		}
+	}
+}
+
+// floatSplit returns twice the transforms of length 2H of the real
+// and imaginary parts at index m and m+H, where c and c2 are the values
+// at index m and H-m of a transform of length H, and w = exp(-iπm/H).
+func floatSplit(c, c2, w complex128) (lo, hi complex128) {
+	c2 = complex(real(c2), -imag(c2))
+	even, odd := c+c2, mulI(c2-c)*w
+	return even + odd, even - odd
+}
+
+// floatJoin is the inverse of floatSplit: it returns the values at
+// index m and H-m of the transform of length H, up to a factor 2,
+// from the products p and q at index m and m+H.
+func floatJoin(p, q, w complex128) (c, c2 complex128) {
+	even, odd := p+q, mulI(mulConj(p-q, w))
+	c, c2 = even+odd, even-odd
+	return c, complex(real(c2), -imag(c2))
+}
+
+// floatPointwise replaces a, the transform of length H = len(a) of
+// pairs of limbs in bit-reversed order, by that of the convolution of
+// the limbs with those transformed in b, or with themselves if b is
+// nil, multiplied by 8. roots is a table of floatRootsTo.
+func floatPointwise(a, b []complex128, roots []complex128) {
+	H := len(a)
+	w := roots[H : 2*H]
+	s := uint(32 - bits.TrailingZeros(uint(H)))
+	for m := 0; m <= H/2; m++ {
+		// Indices of m and H-m in bit-reversed order.
+		i := bits.Reverse32(uint32(m)) >> s
+		i2 := bits.Reverse32(uint32(H-m)&uint32(H-1)) >> s
+		xlo, xhi := floatSplit(a[i], a[i2], w[m])
+		ylo, yhi := xlo, xhi
+		if b != nil {
+			ylo, yhi = floatSplit(b[i], b[i2], w[m])
+		}
+		c, c2 := floatJoin(xlo*ylo, xhi*yhi, w[m])
+		a[i] = c
+		if i2 != i {
+			a[i2] = c2
+		}
+	}
+}
+
+// floatRound replaces the real and imaginary parts of a, multiplied
+// by scale, by the nearest integers. It returns false if one is not
+// within floatMargin of an integer.
+func floatRound(a []complex128, scale float64) bool {
+	for j, u := range a {
+		re, im := real(u)*scale, imag(u)*scale
+		r, i := math.Round(re), math.Round(im)
+		if math.Abs(re-r) > floatMargin || math.Abs(im-i) > floatMargin {
+			return false
+		}
+		a[j] = complex(r, i)
+	}
+	return true
+}
+
+// floatmulTo computes x*y, or x*x if y is nil, by floating-point
+// transforms, using the storage of z for the result if its capacity
+// is large enough. It returns false, leaving z unchanged, if a
+// coefficient is not close enough to an integer.
+func floatmulTo(e *env, z, x, y nat) (nat, bool) {
+	ylen := len(x)
+	if y != nil {
+		ylen = len(y)
+	}
+	k, ok := floatSize(len(x), ylen)
+	if !ok {
+		return nil, false
+	}
+	N := 1 << k
+	defer e.free(e.mark())
+	t := e.clock()
+	roots, roots3 := floatRootsTo(k)
+	// Pairs of limbs.
+	a := e.complexes(N / 2)
+	floatLimbsOf(a, x)
+	floatForward(a, roots, roots3)
+	var b []complex128
+	if y != nil {
+		b = e.complexes(N / 2)
+		floatLimbsOf(b, y)
+		floatForward(b, roots, roots3)
+	}
+	t = e.lap(t, stageForward)
+	floatPointwise(a, b, roots)
+	t = e.lap(t, stagePointwise)
+	floatInverse(a, roots, roots3)
+	t = e.lap(t, stageInverse)
+	defer e.lap(t, stageRecompose)
+
+	// Round coefficients before writing z, which may alias x or y.
+	// They were multiplied by 8 and by the length N/2.
+	if !floatRound(a, 1/float64(4*N)) {
+		return nil, false
+	}
+	length := len(x) + ylen
+	if cap(z) < length {
+		z = make(nat, length)
+		e.allocated(int64(length) * wordBytes)
+	}
+	z = z[:length]
+	// Coefficients are less than 2^45 in absolute value, and the
+	// product has length*floatLimbs limbs, less than N.
+	var carry int64
+	for i := range z {
+		var w Word
+		for l := 0; l < floatLimbs; l += 2 {
+			c := a[(i*floatLimbs+l)/2]
+			v := int64(real(c)) + carry
+			w |= Word(v&0xffff) << uint(l*16)
+			v = int64(imag(c)) + v>>16
+			w |= Word(v&0xffff) << uint(l*16+16)
+			carry = v >> 16
+		}
+		z[i] = w
+	}
+	e.setFloatSize(k)
+	return trim(z), true
+}
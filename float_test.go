@@ -0,0 +1,134 @@
+package bigfft
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestFloatErrorBound(t *testing.T) {
+	// The bound of rounding errors documented in float.go.
+	eps := math.Ldexp(1, -53)
+	beta := 2 * eps
+	for k := uint(1); k <= floatMaxK+1; k++ {
+		N := float64(int(1) << k)
+		n := 3 * float64(k)
+		s := (n+3)*math.Log1p(eps) + (n+1)*math.Log1p(eps*math.Sqrt(5)) + n*math.Log1p(beta)
+		bound := N * math.Ldexp(1, 30) * math.Expm1(s)
+		if k <= floatMaxK && bound >= 0.5 {
+			t.Errorf("rounding error bound %.3f for length 1<<%d", bound, k)
+		}
+		if k > floatMaxK && bound < 0.5 {
+			t.Errorf("floatMaxK=%d is not the largest length: bound %.3f for 1<<%d", floatMaxK, bound, k)
+		}
+	}
+}
+
+func TestFloatMul(t *testing.T) {
+	// Buffers reused from an arena have unspecified contents.
+	e := &env{arena: new(arena)}
+	maxWords := 1 << floatMaxK / floatLimbs
+	for _, s := range [][2]int{
+		{1, 1}, {2, 1}, {3, 3}, {100, 28}, {1000, 1000},
+		{maxWords/2 - 1, maxWords/2 - 1}, {maxWords - 2, 1},
+	} {
+		x, y := rndNat(s[0]), rndNat(s[1])
+		got, ok := floatmulTo(e, nil, x, y)
+		if want := basicMulNat(x, y); !ok || cmpnat(t, got, want) != 0 {
+			t.Errorf("sizes %v: incorrect product (ok=%v)", s, ok)
+		}
+		if _, ok := floatSize(s[0], s[0]); !ok {
+			continue
+		}
+		got, ok = floatmulTo(e, nil, x, nil)
+		if want := basicMulNat(x, x); !ok || cmpnat(t, got, want) != 0 {
+			t.Errorf("size %d: incorrect square (ok=%v)", s[0], ok)
+		}
+	}
+	// Limbs of 0x8000 have the largest balanced values.
+	x := make(nat, maxWords/2-1)
+	for i := range x {
+		x[i] = 0x8000 * (^Word(0) / 0xffff)
+	}
+	if got, ok := floatmulTo(e, nil, x, x); !ok || cmpnat(t, got, basicMulNat(x, x)) != 0 {
+		t.Errorf("incorrect product of extreme limbs (ok=%v)", ok)
+	}
+	if _, ok := floatmulTo(e, nil, rndNat(maxWords), rndNat(1)); ok {
+		t.Errorf("product longer than 1<<floatMaxK limbs is accepted")
+	}
+}
+
+func TestFloatFallback(t *testing.T) {
+	// Inexact coefficients are detected.
+	a := []complex128{4, -8, 12.5 + 1i, 4.2}
+	if floatRound(a[:2], 0.5) != true || a[0] != 2 || a[1] != -4 {
+		t.Errorf("incorrect rounding %v", a[:2])
+	}
+	if floatRound(a[2:], 0.5) != false {
+		t.Errorf("coefficient 6.25 is accepted")
+	}
+
+	c := DefaultConfig()
+	c.FloatThreshold = 10
+	m := NewMultiplier(c)
+	var xi, yi Int
+	for _, s := range [][2]int{{500, 500}, {1500, 2000}, {20, 3000}, {5, 1000}} {
+		xi.SetBits(rndNat(s[0]))
+		yi.SetBits(rndNat(s[1]))
+		yi.Neg(&yi)
+		z, stats := m.MulWithStats(&xi, &yi)
+		if z.Cmp(new(Int).Mul(&xi, &yi)) != 0 {
+			t.Errorf("sizes %v: incorrect product", s)
+		}
+		_, fits := floatSize(s[0], s[1])
+		if want := s[0] > 10 && fits; stats.Float != want {
+			t.Errorf("sizes %v: got Float=%v, expected %v", s, stats.Float, want)
+		}
+	}
+
+	// Products are computed by FFT modulo 2^N+1 if a coefficient
+	// is rejected.
+	defer func(margin float64) { floatMargin = margin }(floatMargin)
+	floatMargin = -1
+	for _, s := range [][2]int{{500, 500}, {1500, 2000}, {20, 1000}} {
+		xi.SetBits(rndNat(s[0]))
+		yi.SetBits(rndNat(s[1]))
+		z, stats := m.MulWithStats(&xi, &yi)
+		if z.Cmp(new(Int).Mul(&xi, &yi)) != 0 {
+			t.Errorf("sizes %v: incorrect product after fallback", s)
+		}
+		if stats.Float || !stats.FFT {
+			t.Errorf("sizes %v: got Float=%v, FFT=%v after fallback", s, stats.Float, stats.FFT)
+		}
+	}
+	z := m.Sqr(&xi)
+	if z.Cmp(new(Int).Mul(&xi, &xi)) != 0 {
+		t.Errorf("incorrect square after fallback")
+	}
+}
+
+func BenchmarkFloatMul(b *testing.B) {
+	c := Config{FloatThreshold: -1}.withDefaults()
+	e := &env{cfg: &c}
+	for _, n := range []int{50, 100, 200, 500, 1000, 1500, 2000} {
+		x, y := rndNat(n), rndNat(n)
+		var xi, yi, zi Int
+		xi.SetBits(x)
+		yi.SetBits(y)
+		b.Run(fmt.Sprintf("big/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				zi.Mul(&xi, &yi)
+			}
+		})
+		b.Run(fmt.Sprintf("float/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				floatmulTo(nil, nil, x, y)
+			}
+		})
+		b.Run(fmt.Sprintf("fft/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fftmulTo(e, nil, x, y)
+			}
+		})
+	}
+}
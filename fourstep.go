@@ -0,0 +1,89 @@
+package bigfft
+
+// Four-step Fourier transforms.
+//
+// For K = K1*K2, writing i = i1 + K1*i2 and j = j2 + K2*j1, the
+// transform of length K is
+//
+//   X[j] = sum(ω^(K2*i1*j1) * ω^(i1*j2) * sum(ω^(K1*i2*j2) * x[i]))
+//
+// which is computed by K1 transforms of length K2 (the inner sums),
+// a multiplication by twiddle factors ω^(i1*j2), and K2 transforms
+// of length K1. When values are large, the vectors of each smaller
+// transform fit in cache, unlike the whole vector.
+
+// fourierFourStep is like fourier, computed in four steps
+// with transforms of length about sqrt(K).
+func (e *env) fourierFourStep(dst, src []fermat, backward bool, n int, k uint) {
+	k1 := k / 2
+	k2 := k - k1
+	K1, K2 := 1<<k1, 1<<k2
+	ω2shift := (4 * n * _W) >> k
+	if backward {
+		ω2shift = -ω2shift
+	}
+	// Transform the K1 interleaved subsequences of src in the rows
+	// of K2 values of dst, and multiply them by twiddle factors.
+	if e.parallel() {
+		e.parallelRange(K1, (n+1)<<k2, func(lo, hi int) {
+			fourierRows(e, dst, src, backward, n, k, lo, hi, ω2shift, make(fermat, fourierBuf*(n+1)))
+		})
+	} else {
+		mark := e.mark()
+		fourierRows(e, dst, src, backward, n, k, 0, K1, ω2shift, fermat(e.nat(fourierBuf*(n+1))))
+		e.free(mark)
+	}
+	if e.cancelled() {
+		return
+	}
+	// Transform the K2 columns of dst of K1 values.
+	if e.parallel() {
+		e.parallelRange(K2, (n+1)<<k1, func(lo, hi int) {
+			// Buffers of other goroutines must not come from the arena.
+			var own *env
+			fourierColumns(e, dst, backward, n, k, lo, hi, own.values(k1, n), make(fermat, fourierBuf*(n+1)))
+		})
+	} else {
+		mark := e.mark()
+		fourierColumns(e, dst, backward, n, k, 0, K2, e.values(k1, n), fermat(e.nat(fourierBuf*(n+1))))
+		e.free(mark)
+	}
+}
+
+// fourierRows computes the rows lo to hi of the first two steps
+// of fourierFourStep. buf is a temporary buffer of fourierBuf values.
+func fourierRows(e *env, dst, src []fermat, backward bool, n int, k uint, lo, hi, ω2shift int, buf fermat) {
+	tmp, tmp2 := buf[:n+1], buf[n+1:2*(n+1)]
+	k2 := k - k/2
+	K2 := 1 << k2
+	for i1 := lo; i1 < hi; i1++ {
+		if e.cancelled() {
+			return
+		}
+		row := dst[i1*K2 : (i1+1)*K2]
+		// Elements of src[i1:] are taken with a stride of K1.
+		e.fourierRec(row, src[i1:], backward, n, k, k2, buf)
+		for j2 := 1; i1 > 0 && j2 < K2; j2++ {
+			tmp.ShiftHalf(row[j2], i1*j2*ω2shift, tmp2)
+			copy(row[j2], tmp)
+		}
+	}
+}
+
+// fourierColumns computes the columns lo to hi of the last step
+// of fourierFourStep, using col, a vector of K1 values, and buf,
+// a temporary buffer of fourierBuf values.
+func fourierColumns(e *env, dst []fermat, backward bool, n int, k uint, lo, hi int, col []fermat, buf fermat) {
+	k1 := k / 2
+	K2 := 1 << (k - k1)
+	for j2 := lo; j2 < hi; j2++ {
+		if e.cancelled() {
+			return
+		}
+		// Elements of dst[j2:] are taken with a stride of K2.
+		e.fourierRec(col, dst[j2:], backward, n, k, k1, buf)
+		for j1, v := range col {
+			copy(dst[j2+j1*K2], v)
+		}
+	}
+}
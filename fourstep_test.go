@@ -0,0 +1,63 @@
+package bigfft
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFourierFourStep(t *testing.T) {
+	for _, tt := range []struct {
+		n int
+		k uint
+	}{{1, 2}, {1, 3}, {2, 5}, {4, 8}, {12, 8}, {8, 9}, {200, 8}} {
+		src := make([]fermat, 1<<tt.k)
+		for i := range src {
+			src[i] = make(fermat, tt.n+1)
+			copy(src[i], rndNat(tt.n))
+		}
+		for _, e := range []*env{nil, {arena: new(arena)}, newEnv(4)} {
+			for _, backward := range []bool{false, true} {
+				want := make([]fermat, 1<<tt.k)
+				got := make([]fermat, 1<<tt.k)
+				for i := range src {
+					want[i] = make(fermat, tt.n+1)
+					got[i] = make(fermat, tt.n+1)
+				}
+				fourier(want, src, backward, tt.n, tt.k)
+				e.fourierFourStep(got, src, backward, tt.n, tt.k)
+				for i := range got {
+					if cmpnat(t, nat(got[i]), nat(want[i])) != 0 {
+						t.Errorf("n=%d, k=%d, backward=%v: incorrect value %d",
+							tt.n, tt.k, backward, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkFourierFourStep(b *testing.B) {
+	for _, tt := range []struct {
+		n int
+		k uint
+	}{{16, 14}, {32, 14}, {64, 14}, {256, 12}} {
+		src := make([]fermat, 1<<tt.k)
+		dst := make([]fermat, 1<<tt.k)
+		for i := range src {
+			src[i] = make(fermat, tt.n+1)
+			dst[i] = make(fermat, tt.n+1)
+			copy(src[i], rndNat(tt.n))
+		}
+		e := &env{arena: new(arena)}
+		b.Run(fmt.Sprintf("recursive/n=%d,k=%d", tt.n, tt.k), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fourier(dst, src, false, tt.n, tt.k)
+			}
+		})
+		b.Run(fmt.Sprintf("fourstep/n=%d,k=%d", tt.n, tt.k), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				e.fourierFourStep(dst, src, false, tt.n, tt.k)
+			}
+		})
+	}
+}
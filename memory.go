@@ -0,0 +1,146 @@
+package bigfft
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"unsafe"
+)
+
+// A MemoryLimitError is returned by MulLimit when a
+// multiplication would need more memory than allowed.
+type MemoryLimitError struct {
+	Need  int64 // estimated memory needed, in bytes.
+	Limit int64 // the allowed amount of memory, in bytes.
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("bigfft: multiplication needs about %d bytes of memory, limit is %d bytes",
+		e.Need, e.Limit)
+}
+
+// EstimateMemory returns an estimate of the total amount of memory
+// (in bytes) allocated by Mul for operands of xbits and ybits bits.
+// It is the sum of the buffers used by transforms and of the result,
+// which is more than the peak usage since some buffers are released
+// before others are allocated.
+func EstimateMemory(xbits, ybits int64) int64 {
+	return defaultMultiplier.EstimateMemory(xbits, ybits)
+}
+
+// MulLimit computes the product x*y and returns it, like Mul.
+// If the multiplication would need more than maxBytes bytes
+// of memory according to EstimateMemory, it is not attempted
+// and MulLimit returns a *MemoryLimitError.
+func MulLimit(x, y *big.Int, maxBytes int64) (*big.Int, error) {
+	return defaultMultiplier.MulLimit(x, y, maxBytes)
+}
+
+// EstimateMemory returns an estimate of the total amount of memory
+// (in bytes) allocated by m.Mul for operands of xbits and ybits bits.
+func (m *Multiplier) EstimateMemory(xbits, ybits int64) int64 {
+	xwords := int((xbits + int64(_W) - 1) / int64(_W))
+	ywords := int((ybits + int64(_W) - 1) / int64(_W))
+	if m.useTransform(xwords, ywords) {
+		return m.cfg.mulMemory(xwords, ywords)
+	}
+	return bigMulMemory(xwords, ywords)
+}
+
+// MulLimit computes the product x*y and returns it, like m.Mul.
+// If the multiplication would need more than maxBytes bytes
+// of memory according to m.EstimateMemory, it is not attempted
+// and MulLimit returns a *MemoryLimitError.
+func (m *Multiplier) MulLimit(x, y *big.Int, maxBytes int64) (*big.Int, error) {
+	need := m.EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+	if need > maxBytes {
+		return nil, &MemoryLimitError{Need: need, Limit: maxBytes}
+	}
+	return m.Mul(x, y), nil
+}
+
+const (
+	wordBytes   = int64(_W / 8)
+	headerBytes = int64(unsafe.Sizeof(fermat(nil)))
+)
+
+// bigMulMemory estimates the amount of memory (in bytes)
+// allocated by math/big to multiply numbers of xwords and
+// ywords words: Karatsuba temporaries take a few times
+// the size of the result.
+func bigMulMemory(xwords, ywords int) int64 {
+	return 4 * int64(xwords+ywords) * wordBytes
+}
+
+// mulMemory estimates the total amount of memory (in bytes) allocated
+// by the FFT multiplication of numbers of xwords and ywords words.
+func (c *Config) mulMemory(xwords, ywords int) int64 {
+	if xwords < ywords {
+		xwords, ywords = ywords, xwords
+	}
+	words := xwords + ywords
+	if c.useFloat(xwords, ywords) {
+		// A vector of 1<<k/2 pairs of limbs for each operand,
+		// and the result.
+		k, _ := floatSize(xwords, ywords)
+		return 2*16<<(k-1) + int64(words)*wordBytes
+	}
+	if c.useNTT(words) {
+		// Three vectors of residues for each operand, the
+		// buffers of recomposition, and the result.
+		L := int64(1) << uint(bits.Len(uint(words-2)))
+		return 6*L*8 + int64(2*nttWords+1+words)*wordBytes
+	}
+	if c.unbalanced(xwords, ywords) {
+		// Only the transform of a block product is needed at a time.
+		words = unbalancedBlock*ywords + ywords
+	}
+	k, m := c.fftSize(words)
+	n := valueSize(k, m, 2)
+	// Both operands are copied in a buffer before being
+	// transformed, and the pointwise product allocates a new
+	// buffer, while the inverse transform reuses the buffer of
+	// the first operand. Each buffer is a slice of K values
+	// of n+1 words.
+	buffers := 5 * (int64(n+1)<<k*wordBytes + int64(1)<<k*headerBytes)
+	// Temporary buffers for pointwise products, for the three
+	// Fourier transforms, and for recomposition.
+	buffers += int64(8*n+(3*fourierBuf+1)*(n+1)) * wordBytes
+	// The chunks of both operands: their headers, and a copy
+	// of their last chunk.
+	buffers += 2 * (int64(1)<<k*headerBytes + int64(m)*wordBytes)
+	buffers += c.recursionMemory(1<<k, n)
+	// The result.
+	return buffers + int64(xwords+ywords)*wordBytes
+}
+
+// recursionMemory estimates the amount of memory (in bytes) allocated
+// by count pointwise products of values of n words, if they are
+// computed by negacyclic convolution.
+func (c *Config) recursionMemory(count, n int) int64 {
+	if !c.recurses(n) {
+		return 0
+	}
+	return int64(count) * c.negacyclicMemory(n)
+}
+
+// negacyclicMemory estimates the amount of memory (in bytes)
+// allocated by a product modulo 2^(n*W)+1 by mulNegacyclic.
+func (c *Config) negacyclicMemory(n int) int64 {
+	k, _ := c.negacyclicSize(n)
+	m := n >> k
+	np := valueSize(k, m, 0)
+	K := int64(1) << k
+	// Two vectors of K values of np+1 words for the forward
+	// transform of each operand, one for their pointwise product
+	// and one for the inverse transform.
+	buffers := 6 * (K*int64(np+1)*wordBytes + K*headerBytes)
+	// Temporary buffers for twisting and untwisting, for pointwise
+	// products and for the three Fourier transforms.
+	buffers += int64(3*(np+1)+8*np+3*fourierBuf*(np+1)) * wordBytes
+	// The chunks of both operands and of the result.
+	buffers += (3*K+2)*headerBytes + int64(2*m)*wordBytes
+	// Positive and negative coefficients, and their absolute values.
+	buffers += int64(2*(2*n+1)+np+1) * wordBytes
+	return buffers + c.recursionMemory(1<<k, np)
+}
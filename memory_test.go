@@ -0,0 +1,110 @@
+package bigfft
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	sizes := []int{50e3, 200e3, 1e6, 10e6}
+	var x, y Int
+	for _, size := range sizes {
+		x.SetBits(rndNat(size / _W))
+		y.SetBits(rndNat(size / _W))
+		est := EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+		var m1, m2 runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m1)
+		_, stats := MulWithStats(&x, &y)
+		runtime.ReadMemStats(&m2)
+		alloc := int64(m2.TotalAlloc - m1.TotalAlloc)
+		t.Logf("size %d: estimated %d bytes, allocated %d bytes (%d by buffers)",
+			size, est, alloc, stats.Bytes)
+		// TotalAlloc also counts allocations of the runtime and
+		// of the race detector, so it is only checked loosely.
+		if alloc > 2*est {
+			t.Errorf("allocated %d bytes for size %d, more than twice the estimate %d",
+				alloc, size, est)
+		}
+		// Buffers exclude the temporaries of math/big, which are
+		// a small part of the estimate.
+		if stats.FFT && (stats.Bytes > est || stats.Bytes < est/2) {
+			t.Errorf("estimate %d bytes is too far from %d bytes of buffers for size %d",
+				est, stats.Bytes, size)
+		}
+	}
+
+	// Blockwise multiplication needs less memory.
+	c := DefaultConfig()
+	c.UnbalancedRatio = 1 << 30
+	full := c.mulMemory(1e6/_W, 20e6/_W)
+	small := EstimateMemory(1e6, 20e6)
+	if full <= small {
+		t.Errorf("unbalanced estimate %d >= full estimate %d", small, full)
+	}
+
+	// Floating-point transforms.
+	x.SetBits(rndNat(1750))
+	y.SetBits(rndNat(1790))
+	_, stats := MulWithStats(&x, &y)
+	est := EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+	if !stats.Float || stats.Bytes > est || stats.Bytes < est/2 {
+		t.Errorf("float estimate %d bytes is too far from %d bytes of buffers", est, stats.Bytes)
+	}
+
+	// Number-theoretic transforms.
+	x.SetBits(rndNat(10e6 / _W))
+	y.SetBits(rndNat(10e6 / _W))
+	m := NewMultiplier(Config{NTTThreshold: 1 << 20})
+	_, stats = m.MulWithStats(&x, &y)
+	est = m.EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+	if !stats.NTT || stats.Bytes > est || stats.Bytes < est/2 {
+		t.Errorf("NTT estimate %d bytes is too far from %d bytes of buffers", est, stats.Bytes)
+	}
+
+	// Pointwise products by negacyclic convolution.
+	m = NewMultiplier(Config{RecursionThreshold: 64})
+	x.SetBits(rndNat(80e3))
+	y.SetBits(rndNat(80e3))
+	_, stats = m.MulWithStats(&x, &y)
+	if !m.cfg.recurses(stats.N) {
+		t.Fatalf("values of %d words are not multiplied recursively", stats.N)
+	}
+	est = m.EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+	flat := NewMultiplier(Config{RecursionThreshold: -1}).EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+	t.Logf("recursive products: estimated %d bytes (%d without recursion), %d bytes of buffers",
+		est, flat, stats.Bytes)
+	if stats.Bytes <= flat {
+		t.Errorf("%d bytes of buffers do not count recursive products, estimated %d bytes without them",
+			stats.Bytes, flat)
+	}
+	if stats.Bytes > est || stats.Bytes < est/2 {
+		t.Errorf("estimate %d bytes is too far from %d bytes of buffers with recursive products",
+			est, stats.Bytes)
+	}
+}
+
+func TestMulLimit(t *testing.T) {
+	var x, y Int
+	x.SetBits(rndNat(1e6 / _W))
+	y.SetBits(rndNat(1e6 / _W))
+	need := EstimateMemory(int64(x.BitLen()), int64(y.BitLen()))
+
+	z, err := MulLimit(&x, &y, need)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.Cmp(new(Int).Mul(&x, &y)) != 0 {
+		t.Errorf("incorrect product")
+	}
+
+	z, err = MulLimit(&x, &y, need-1)
+	merr, ok := err.(*MemoryLimitError)
+	if z != nil || !ok {
+		t.Fatalf("got (%v, %v), expected a *MemoryLimitError", z, err)
+	}
+	if merr.Need != need || merr.Limit != need-1 {
+		t.Errorf("unexpected error %+v", merr)
+	}
+	t.Log(err)
+}
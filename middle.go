@@ -0,0 +1,232 @@
+package bigfft
+
+import (
+	"math/big"
+)
+
+// Middle products.
+//
+// A cyclic convolution of length L, computing the product of
+// polynomials modulo X^L-1, adds coefficient c_(j+L) of the product
+// to c_j. If the product has at most jlo+L coefficients, coefficients
+// from jlo to L are not mixed with others. For the middle third of
+// a product of 2n by n words, L only needs to cover 2n words instead
+// of 3n.
+//
+// Coefficients below jlo are not known, but their sum S is less
+// than b^((jlo+3)m), where m is the size of chunks and b the base of
+// big.Word. It only adds a carry to word lo of the sum R of the known
+// coefficients, which happens if words (jlo+3)m to lo of R are all
+// ones. In that case, which is rare unless operands have long runs of
+// ones, x*y mod b^lo is computed by a product of lo words: it is
+// R+S mod b^lo, which is less than R mod b^lo exactly if there is
+// a carry.
+
+// MulMiddle computes the words lo to hi of x*y and returns them:
+// the result has the sign of x*y and its absolute value is
+// floor(|x*y| / b^lo) mod b^(hi-lo), where b is the base of big.Word.
+//
+// Its cost is about that of a product of words
+// max(len(x.Bits())+len(y.Bits())-lo, hi).
+func MulMiddle(x, y *big.Int, lo, hi int) *big.Int {
+	return defaultMultiplier.MulMiddle(x, y, lo, hi)
+}
+
+// MulMiddle is like the MulMiddle function, using the configuration of m.
+func (m *Multiplier) MulMiddle(x, y *big.Int, lo, hi int) *big.Int {
+	if lo < 0 {
+		lo = 0
+	}
+	if hi <= lo {
+		return new(big.Int)
+	}
+	var zb nat
+	if m.useFFT(len(x.Bits()), len(y.Bits())) {
+		zb = fftmulMiddle(m.env(), x.Bits(), y.Bits(), lo, hi)
+	} else {
+		zb = new(big.Int).Mul(x, y).Bits()
+		if hi > len(zb) {
+			hi = len(zb)
+		}
+		if lo < hi {
+			zb = trim(zb[lo:hi])
+		} else {
+			zb = nil
+		}
+	}
+	z := new(big.Int).SetBits(zb)
+	if x.Sign()*y.Sign() < 0 {
+		z.Neg(z)
+	}
+	return z
+}
+
+// fftmulMiddle computes the words lo to hi of x*y and returns them.
+func fftmulMiddle(e *env, x, y nat, lo, hi int) nat {
+	if hi > len(x)+len(y) {
+		hi = len(x) + len(y)
+	}
+	if lo >= hi {
+		return nil
+	}
+	words := len(x) + len(y) - lo
+	for _, w := range []int{hi, len(x), len(y)} {
+		if w > words {
+			words = w
+		}
+	}
+	k, m := e.config().fftSize(words)
+	if k < 3 {
+		k = 3
+	}
+	K := 1 << k
+	// Make the K chunks cover words with 5 chunks to spare: with
+	// jlo = lo/m-4 and jhi = hi/m+1, the product has less than
+	// jlo+K coefficients, and x, y have less than K chunks.
+	if mm := words/(K-5) + 1; mm > m {
+		m = mm
+	}
+	e.setSize(k, m, 1)
+	defer e.free(e.mark())
+	n := valueSize(k, m, 2)
+	xp := e.polyFromNat(x, k, m)
+	yp := e.polyFromNat(y, k, m)
+	xv, yv := xp.Transform(n), yp.Transform(n)
+	pv := xv.Mul(&yv)
+	p := pv.InvTransform()
+
+	jhi := (hi + m - 1) / m
+	if jhi > K {
+		jhi = K
+	}
+	jlo := lo/m - 4
+	if jlo <= 0 {
+		// All coefficients are known.
+		return e.recompose(p.a[:jhi], 0, m, lo, hi)
+	}
+	// The words of R from jlo*m, where R is the sum
+	// of the known coefficients, split at word lo.
+	off := jlo * m
+	z := e.recompose(p.a[jlo:jhi], off, m, off, hi)
+	var r nat
+	if len(z) > lo-off {
+		r, z = z[:lo-off], trim(z[lo-off:])
+	} else {
+		r, z = z, nil
+	}
+	if len(r) < lo-off {
+		// Words of R below lo are not all ones.
+		return z
+	}
+	for _, w := range r[3*m:] {
+		if w != ^big.Word(0) {
+			return z
+		}
+	}
+	low := make(nat, lo-off)
+	if l := lowProduct(e, x, y, lo); len(l) > off {
+		copy(low, l[off:])
+	}
+	if less(low, r) {
+		// Add the carry modulo b^(hi-lo).
+		z = append(z, 0)
+		addVW(z, z, 1)
+		if len(z) > hi-lo {
+			z = z[:hi-lo]
+		}
+		z = trim(z)
+	}
+	return z
+}
+
+// lowProduct returns x*y mod b^words, where b is the base of big.Word.
+func lowProduct(e *env, x, y nat, words int) nat {
+	x, y = lowWords(x, words), lowWords(y, words)
+	if len(x) == 0 || len(y) == 0 {
+		return nil
+	}
+	var xi, yi, z big.Int
+	xi.SetBits(x)
+	yi.SetBits(y)
+	// Products are computed like by m.MulTo, in e.
+	m := Multiplier{cfg: *e.config()}
+	if m.useTransform(len(x), len(y)) {
+		mulFFTTo(e, &z, &xi, &yi)
+	} else {
+		z.Mul(&xi, &yi)
+	}
+	return lowWords(z.Bits(), words)
+}
+
+// lowWords returns the low words words of x.
+func lowWords(x nat, words int) nat {
+	if len(x) > words {
+		x = x[:words]
+	}
+	return trim(x)
+}
+
+// recompose returns the words lo to hi of the sum of a[i]*b^(off+i*m),
+// where b is the base of big.Word.
+func (e *env) recompose(a []nat, off, m, lo, hi int) nat {
+	defer e.lap(e.clock(), stageRecompose)
+	l := m
+	for _, c := range a {
+		if len(c) > l {
+			l = len(c)
+		}
+	}
+	z := make(nat, hi-lo)
+	e.allocated(int64(len(z)) * wordBytes)
+	// acc holds the words of the sum from offset pos,
+	// to which later coefficients are added.
+	mark := e.mark()
+	acc := e.nat(l + 1)
+	for i := range acc {
+		acc[i] = 0
+	}
+	pos := off
+	for _, c := range a {
+		if pos >= hi {
+			break
+		}
+		if carry := addVV(acc[:len(c)], acc[:len(c)], c); carry != 0 {
+			addVW(acc[len(c):], acc[len(c):], carry)
+		}
+		// The first m words are final.
+		putWords(z, lo, pos, acc[:m])
+		copy(acc, acc[m:])
+		for i := len(acc) - m; i < len(acc); i++ {
+			acc[i] = 0
+		}
+		pos += m
+	}
+	putWords(z, lo, pos, acc)
+	e.free(mark)
+	return trim(z)
+}
+
+// putWords copies w, the words of a number from offset off,
+// to z, which holds its words from offset lo.
+func putWords(z nat, lo, off int, w nat) {
+	if off < lo {
+		if off+len(w) <= lo {
+			return
+		}
+		w = w[lo-off:]
+		off = lo
+	}
+	if off-lo < len(z) {
+		copy(z[off-lo:], w)
+	}
+}
+
+// less reports whether x < y, for numbers of the same length.
+func less(x, y nat) bool {
+	for i := len(x) - 1; i >= 0; i-- {
+		if x[i] != y[i] {
+			return x[i] < y[i]
+		}
+	}
+	return false
+}
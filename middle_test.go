@@ -0,0 +1,72 @@
+package bigfft
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestMulMiddle(t *testing.T) {
+	small := NewMultiplier(Config{Threshold: 8})
+	for _, m := range []*Multiplier{defaultMultiplier, small} {
+		for _, s := range [][4]int{
+			{4000, 2000, 2000, 4000}, {6000, 2500, 0, 3000}, {3000, 3000, 1000, 5000},
+			{2000, 5000, 6000, 7000}, {40, 30, 20, 50}, {5000, 3000, 7000, 9000},
+			{3000, 2000, 100, 200},
+		} {
+			var x, y Int
+			x.SetBits(rndNat(s[0]))
+			y.SetBits(rndNat(s[1]))
+			y.Neg(&y)
+			testMulMiddle(t, m, &x, &y, s[2], s[3])
+		}
+		// Runs of ones in the product make the carry
+		// from lower words depend on all of them.
+		for _, s := range [][4]int{
+			{3000, 2000, 2000, 4000}, {4000, 4000, 3000, 5000}, {40, 30, 30, 50},
+		} {
+			var x, y Int
+			one := big.NewInt(1)
+			x.Sub(x.Lsh(one, uint(s[0]*_W)), one)
+			y.Add(y.Lsh(one, uint(s[1]*_W)), one)
+			testMulMiddle(t, m, &x, &y, s[2], s[3])
+			y.Sub(y.Lsh(one, uint(s[1]*_W)), one)
+			testMulMiddle(t, m, &x, &y, s[2], s[3])
+			y.Add(&y, one.Lsh(one, uint(s[2]*_W)))
+			testMulMiddle(t, m, &x, &y, s[2], s[3])
+		}
+	}
+}
+
+func testMulMiddle(t *testing.T, m *Multiplier, x, y *Int, lo, hi int) {
+	mod := new(Int).Lsh(big.NewInt(1), uint((hi-lo)*_W))
+	want := new(Int).Mul(x, y)
+	want.Abs(want)
+	want.Rsh(want, uint(lo*_W))
+	want.Mod(want, mod)
+	if x.Sign()*y.Sign() < 0 {
+		want.Neg(want)
+	}
+	if got := m.MulMiddle(x, y, lo, hi); got.Cmp(want) != 0 {
+		t.Errorf("sizes %d, %d, words %d to %d, threshold %d: middle product off by %s",
+			len(x.Bits()), len(y.Bits()), lo, hi, m.Config().Threshold, new(Int).Sub(want, got))
+	}
+}
+
+func BenchmarkMulMiddle(b *testing.B) {
+	for _, n := range []int{1e4, 1e5, 1e6} {
+		var x, y Int
+		x.SetBits(rndNat(2 * n))
+		y.SetBits(rndNat(n))
+		b.Run(fmt.Sprintf("mul/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Mul(&x, &y)
+			}
+		})
+		b.Run(fmt.Sprintf("middle/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				MulMiddle(&x, &y, n, 2*n)
+			}
+		})
+	}
+}
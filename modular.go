@@ -0,0 +1,316 @@
+package bigfft
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// Products modulo 2^N+1 and 2^N-1.
+//
+// If N = K*b with K = 1<<k, cutting x and y in K chunks of b bits
+// makes them polynomials evaluated at 2^b, and since 2^(bK) = -1,
+// their product modulo 2^N+1 is their product modulo X^K+1, computed
+// by NTransform and InvNTransform (see negacyclic.go). Likewise,
+// since 2^(bK) = 1 modulo 2^N-1, the product modulo 2^N-1 is the
+// product modulo X^K-1 computed by poly.Mul, whose coefficients are
+// added with the carries above 2^N wrapped around. Chunks need not
+// be made of whole words.
+//
+// If N has too few factors of 2 to cut x and y in at least 8 chunks,
+// x*y is instead recovered from its residues modulo 2^M-1 and 2^M+1
+// for a slightly larger M which can be cut in chunks, and reduced:
+// since 2^M-1 = -2 modulo 2^M+1, the Chinese remainder theorem only
+// needs shifts.
+
+// MulModFermat computes x*y mod 2^N+1 and returns it, in [0, 2^N].
+// x and y may be any integers.
+//
+// If N is a multiple of 8, the product is computed by a negacyclic
+// convolution of the chunks of x and y, without computing x*y.
+// Otherwise x*y is computed by a cyclic and a negacyclic convolution
+// of about N bits each, which costs about as much as Mul, and reduced.
+// If N is small, math/big is used.
+func MulModFermat(x, y *big.Int, N uint) *big.Int {
+	return defaultMultiplier.MulModFermat(x, y, N)
+}
+
+// MulModFermat is like the MulModFermat function,
+// using the configuration of m.
+func (m *Multiplier) MulModFermat(x, y *big.Int, N uint) *big.Int {
+	if N == 0 {
+		z := new(big.Int).Mul(x, y)
+		return z.Mod(z, big.NewInt(2))
+	}
+	square := x == y
+	x, y = reduceFermat(x, N), reduceFermat(y, N)
+	// -1 = 2^N cannot be cut in chunks.
+	if uint(x.BitLen()) > N {
+		return reduceFermat(y.Neg(y), N)
+	}
+	if uint(y.BitLen()) > N {
+		return reduceFermat(x.Neg(x), N)
+	}
+	words := int((N + uint(_W) - 1) / uint(_W))
+	if !m.useFFT(words, words) {
+		return reduceFermat(x.Mul(x, y), N)
+	}
+	yb := y.Bits()
+	if square {
+		yb = nil
+	}
+	if k, ok := m.cfg.modularSize(N); ok {
+		return reduceFermat(fftmulModFermat(m.env(), x.Bits(), yb, N, k), N)
+	}
+	return reduceFermat(fftmulPadded(m.env(), x.Bits(), yb, m.cfg.paddedSize(N)), N)
+}
+
+// modularSize returns the transform length k to compute products
+// modulo 2^N+1 or 2^N-1 by negacyclic or cyclic convolution, and
+// false if N cannot be cut in enough chunks.
+func (c *Config) modularSize(N uint) (k uint, ok bool) {
+	// Like negacyclicSize, but chunks only need to cut N bits,
+	// and all multiples of 8 are accepted.
+	k, _ = c.fftSize(int(N/uint(_W)) / 4)
+	if k < negacyclicMinK {
+		k = negacyclicMinK
+	}
+	if tz := uint(bits.TrailingZeros(N)); tz < k {
+		k = tz
+	}
+	return k, k >= negacyclicMinK
+}
+
+// paddedSize returns the smallest M >= N accepted by modularSize.
+func (c *Config) paddedSize(N uint) uint {
+	k, _ := c.fftSize(int(N/uint(_W)) / 4)
+	if k < negacyclicMinK {
+		k = negacyclicMinK
+	}
+	K := uint(1) << k
+	return (N + K - 1) &^ (K - 1)
+}
+
+// fftmulPadded computes x*y, or x*x if y is nil, where x and y are
+// less than 2^M, from its residues modulo 2^M-1 and 2^M+1. M must be
+// accepted by modularSize.
+func fftmulPadded(e *env, x, y nat, M uint) *big.Int {
+	k, _ := e.config().modularSize(M)
+	a := reduceMersenne(fftmulModMersenne(e, x, y, M, k), M)
+	c := reduceFermat(fftmulModFermat(e, x, y, M, k), M)
+	// x*y = a + (2^M-1)*t, where t = (c-a)/(2^M-1) = (c-a)*2^(M-1)
+	// modulo 2^M+1, since 2^M-1 = -2 and 1/2 = -2^(M-1).
+	t := c.Sub(c, a)
+	t = reduceFermat(t.Lsh(t, M-1), M)
+	z := new(big.Int).Lsh(t, M)
+	z.Sub(z, t)
+	return z.Add(z, a)
+}
+
+// reduceFermat returns x mod 2^N+1, in [0, 2^N].
+func reduceFermat(x *big.Int, N uint) *big.Int {
+	neg := x.Sign() < 0
+	r := new(big.Int).Abs(x)
+	var hi, t big.Int
+	for uint(r.BitLen()) > N {
+		// r = hi*2^N + lo is lo - hi modulo 2^N+1.
+		hi.Rsh(r, N)
+		r.Sub(r, t.Lsh(&hi, N))
+		r.Sub(r, &hi)
+		if r.Sign() < 0 {
+			r.Neg(r)
+			neg = !neg
+		}
+	}
+	if neg && r.Sign() != 0 {
+		t.Lsh(big.NewInt(1), N)
+		r.Sub(t.Add(&t, big.NewInt(1)), r)
+	}
+	return r
+}
+
+// fftmulModFermat computes x*y, or x*x if y is nil, modulo 2^N+1
+// using a transform of length 1<<k, where x and y are less than 2^N,
+// and returns a number congruent to it.
+func fftmulModFermat(e *env, x, y nat, N, k uint) *big.Int {
+	K := 1 << k
+	b := int(N >> k) // the number of bits of chunks.
+	m := (b + _W - 1) / _W
+	e.setSize(k, m, 1)
+	defer e.free(e.mark())
+	// Coefficients of the product are in (-K*2^(2b), K*2^(2b)).
+	np := valueSize(k, m, 0)
+	xp := e.polyFromBits(x, k, b)
+	xv := xp.NTransform(np)
+	var rv polValues
+	if y == nil {
+		rv = xv.Sqr()
+	} else {
+		yp := e.polyFromBits(y, k, b)
+		yv := yp.NTransform(np)
+		rv = xv.Mul(&yv)
+	}
+	rp := rv.InvNTransform()
+
+	defer e.lap(e.clock(), stageRecompose)
+	// Add positive and negative coefficients separately.
+	words := (K-1)*b/_W + np + 3
+	pos := e.nat(words)
+	neg := e.nat(words)
+	for i := range pos {
+		pos[i], neg[i] = 0, 0
+	}
+	abs := fermat(e.nat(np + 1))
+	tmp := e.nat(np + 2)
+	for i, c := range rp.a {
+		c := fermat(c)
+		acc := pos
+		if c[np] != 0 || c[np-1]>>(_W-1) != 0 {
+			// c is more than 2^(np*_W-1), it represents c - 2^(np*_W) - 1.
+			for j := range abs {
+				abs[j] = 0
+			}
+			c = abs.Sub(abs, c)
+			acc = neg
+		}
+		addBits(acc, nat(c), i*b, tmp)
+	}
+	var p, q big.Int
+	p.SetBits(trim(pos))
+	q.SetBits(trim(neg))
+	return new(big.Int).Sub(&p, &q)
+}
+
+// polyFromBits cuts x, which has at most b<<k bits, in 1<<k
+// chunks of b bits, allocated in e.
+func (e *env) polyFromBits(x nat, k uint, b int) poly {
+	m := (b + _W - 1) / _W
+	p := poly{k: k, m: m, env: e}
+	p.a = e.nats(1 << k)
+	for i := range p.a {
+		p.a[i] = e.nat(m)
+		getBits(p.a[i], x, i*b, b)
+	}
+	return p
+}
+
+// getBits sets z to the bits of x from offset off, keeping
+// the given number of bits, which must fit in z.
+func getBits(z, x nat, off, bits int) {
+	w, s := off/_W, uint(off%_W)
+	for i := range z {
+		var v Word
+		if j := w + i; j < len(x) {
+			v = x[j] >> s
+			if s > 0 && j+1 < len(x) {
+				v |= x[j+1] << (uint(_W) - s)
+			}
+		}
+		z[i] = v
+	}
+	if bits < len(z)*_W {
+		z[bits/_W] &= 1<<uint(bits%_W) - 1
+		for i := bits/_W + 1; i < len(z); i++ {
+			z[i] = 0
+		}
+	}
+}
+
+// addBits adds x shifted left by off bits to z, which must be
+// large enough. tmp is a temporary buffer of len(x)+1 words.
+func addBits(z, x nat, off int, tmp nat) {
+	x = trim(x)
+	if len(x) == 0 {
+		return
+	}
+	t := tmp[:len(x)+1]
+	t[len(x)] = shlVU(t[:len(x)], x, uint(off%_W))
+	t = trim(t)
+	zw := z[off/_W:]
+	if carry := addVV(zw[:len(t)], zw[:len(t)], t); carry != 0 {
+		addVW(zw[len(t):], zw[len(t):], carry)
+	}
+}
+
+// MulModMersenne computes x*y mod 2^N-1 and returns it, in [0, 2^N-1).
+// x and y may be any integers, and N must be positive.
+//
+// If N is a multiple of a large enough power of 2, the product is
+// computed by a cyclic convolution of the chunks of x and y, without
+// computing x*y. Otherwise x*y is computed by a cyclic and a
+// negacyclic convolution of about N bits each, which costs about as
+// much as Mul, and reduced. If N is small, math/big is used.
+func MulModMersenne(x, y *big.Int, N uint) *big.Int {
+	return defaultMultiplier.MulModMersenne(x, y, N)
+}
+
+// MulModMersenne is like the MulModMersenne function,
+// using the configuration of m.
+func (m *Multiplier) MulModMersenne(x, y *big.Int, N uint) *big.Int {
+	if N == 0 {
+		panic("bigfft: MulModMersenne with N = 0")
+	}
+	square := x == y
+	x, y = reduceMersenne(x, N), reduceMersenne(y, N)
+	words := int((N + uint(_W) - 1) / uint(_W))
+	if !m.useFFT(words, words) {
+		return reduceMersenne(m.MulTo(x, x, y), N)
+	}
+	yb := y.Bits()
+	if square {
+		yb = nil
+	}
+	if k, ok := m.cfg.modularSize(N); ok {
+		return reduceMersenne(fftmulModMersenne(m.env(), x.Bits(), yb, N, k), N)
+	}
+	return reduceMersenne(fftmulPadded(m.env(), x.Bits(), yb, m.cfg.paddedSize(N)), N)
+}
+
+// reduceMersenne returns x mod 2^N-1, in [0, 2^N-1).
+func reduceMersenne(x *big.Int, N uint) *big.Int {
+	r := new(big.Int).Abs(x)
+	var hi, t big.Int
+	for uint(r.BitLen()) > N {
+		// r = hi*2^N + lo is lo + hi modulo 2^N-1.
+		hi.Rsh(r, N)
+		r.Sub(r, t.Lsh(&hi, N))
+		r.Add(r, &hi)
+	}
+	t.Lsh(big.NewInt(1), N)
+	t.Sub(&t, big.NewInt(1))
+	if r.Cmp(&t) == 0 {
+		r.SetInt64(0)
+	}
+	if x.Sign() < 0 && r.Sign() != 0 {
+		r.Sub(&t, r)
+	}
+	return r
+}
+
+// fftmulModMersenne computes x*y, or x*x if y is nil, modulo 2^N-1
+// using a transform of length 1<<k, where x and y are less than 2^N,
+// and returns a number congruent to it.
+func fftmulModMersenne(e *env, x, y nat, N, k uint) *big.Int {
+	K := 1 << k
+	b := int(N >> k) // the number of bits of chunks.
+	m := (b + _W - 1) / _W
+	e.setSize(k, m, 1)
+	defer e.free(e.mark())
+	xp := e.polyFromBits(x, k, b)
+	var rp poly
+	if y == nil {
+		rp = xp.Sqr()
+	} else {
+		yp := e.polyFromBits(y, k, b)
+		rp = xp.Mul(&yp)
+	}
+
+	defer e.lap(e.clock(), stageRecompose)
+	n := len(rp.a[0]) - 1
+	z := make(nat, (K-1)*b/_W+n+3)
+	e.allocated(int64(len(z)) * wordBytes)
+	tmp := e.nat(n + 2)
+	for i, c := range rp.a {
+		addBits(z, c, i*b, tmp)
+	}
+	return new(big.Int).SetBits(trim(z))
+}
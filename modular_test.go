@@ -0,0 +1,145 @@
+package bigfft
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestMulModFermat(t *testing.T) {
+	small := NewMultiplier(Config{Threshold: 8})
+	for _, m := range []*Multiplier{defaultMultiplier, small} {
+		for _, N := range []uint{0, 1, 8, 999, 1000, 1 << 10, 3 << 12, 5 << 15, 1 << 17, 3 << 17,
+			100001, 1<<17 + 4, 1<<17 + 8, 3<<17 + 1} {
+			mod := new(Int).Lsh(big.NewInt(1), N)
+			mod.Add(mod, big.NewInt(1))
+			words := int(N)/_W + 1
+			for i := 0; i < 5; i++ {
+				var x, y Int
+				x.SetBits(rndNat(words))
+				y.SetBits(rndNat(words))
+				switch i {
+				case 1:
+					x.Neg(&x)
+				case 2:
+					x.Sub(mod, big.NewInt(1)) // -1
+				case 3:
+					y.SetBits(rndNat(3 * words))
+				}
+				if i < 3 {
+					x.Mod(&x, mod)
+					y.Mod(&y, mod)
+				}
+				want := new(Int).Mul(&x, &y)
+				want.Mod(want, mod)
+				if got := m.MulModFermat(&x, &y, N); got.Cmp(want) != 0 {
+					t.Errorf("N=%d, threshold %d: incorrect product %d",
+						N, m.Config().Threshold, i)
+				}
+				want.Mul(&x, &x)
+				want.Mod(want, mod)
+				if got := m.MulModFermat(&x, &x, N); got.Cmp(want) != 0 {
+					t.Errorf("N=%d, threshold %d: incorrect square %d",
+						N, m.Config().Threshold, i)
+				}
+			}
+		}
+	}
+}
+
+func TestMulModFermatSize(t *testing.T) {
+	// Sizes with few factors of 2 are padded, whether
+	// math/big or FFT is used.
+	var x, y Int
+	x.SetBits(rndNat(6000))
+	y.SetBits(rndNat(6000))
+	for _, N := range []uint{3, 999, 1<<10 + 4, 200001, 250002, 299997} {
+		mod := new(Int).Lsh(big.NewInt(1), N)
+		mod.Add(mod, big.NewInt(1))
+		want := new(Int).Mul(&x, &y)
+		want.Mod(want, mod)
+		if got := MulModFermat(&x, &y, N); got.Cmp(want) != 0 {
+			t.Errorf("N=%d: incorrect product", N)
+		}
+	}
+}
+
+func BenchmarkMulMod(b *testing.B) {
+	for _, N := range []uint{1 << 18, 1 << 21, 1 << 24} {
+		mod := new(Int).Lsh(big.NewInt(1), N)
+		mod.Add(mod, big.NewInt(1))
+		var x, y Int
+		x.SetBits(rndNat(int(N) / _W))
+		y.SetBits(rndNat(int(N) / _W))
+		b.Run(fmt.Sprintf("mul/%d", N), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				z := Mul(&x, &y)
+				z.Mod(z, mod)
+			}
+		})
+		b.Run(fmt.Sprintf("fermat/%d", N), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				MulModFermat(&x, &y, N)
+			}
+		})
+		b.Run(fmt.Sprintf("mersenne/%d", N), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				MulModMersenne(&x, &y, N)
+			}
+		})
+	}
+}
+
+func TestMulModMersenne(t *testing.T) {
+	small := NewMultiplier(Config{Threshold: 8})
+	for _, m := range []*Multiplier{defaultMultiplier, small} {
+		for _, N := range []uint{1, 2, 61, 999, 1000, 1 << 10, 3 << 12, 5 << 15, 1 << 17, 3 << 17,
+			100001, 1<<17 + 4, 3<<17 + 1} {
+			mod := new(Int).Lsh(big.NewInt(1), N)
+			mod.Sub(mod, big.NewInt(1))
+			words := int(N)/_W + 1
+			for i := 0; i < 5; i++ {
+				var x, y Int
+				x.SetBits(rndNat(words))
+				y.SetBits(rndNat(words))
+				switch i {
+				case 1:
+					x.Neg(&x)
+				case 2:
+					x.Set(mod) // 0
+				case 3:
+					y.SetBits(rndNat(3 * words))
+				}
+				if i < 2 {
+					x.Mod(&x, mod)
+					y.Mod(&y, mod)
+				}
+				want := new(Int).Mul(&x, &y)
+				want.Mod(want, mod)
+				if got := m.MulModMersenne(&x, &y, N); got.Cmp(want) != 0 {
+					t.Errorf("N=%d, threshold %d: incorrect product %d",
+						N, m.Config().Threshold, i)
+				}
+				want.Mul(&x, &x)
+				want.Mod(want, mod)
+				if got := m.MulModMersenne(&x, &x, N); got.Cmp(want) != 0 {
+					t.Errorf("N=%d, threshold %d: incorrect square %d",
+						N, m.Config().Threshold, i)
+				}
+			}
+		}
+	}
+}
+
+func TestPaddedSize(t *testing.T) {
+	for _, N := range []uint{1, 999, 100001, 1<<17 + 4, 3<<17 + 1, 1 << 17} {
+		M := defaultConfig.paddedSize(N)
+		k, ok := defaultConfig.modularSize(M)
+		if M < N || !ok {
+			t.Errorf("N=%d: padded size %d cannot be cut in chunks", N, M)
+		}
+		if M-N >= 1<<k {
+			t.Errorf("N=%d: padded size %d is too large for length %d", N, M, 1<<k)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bigfft
+
+import (
+	"math/bits"
+)
+
+// Products modulo 2^(n*_W)+1 by negacyclic convolution.
+//
+// Cutting x and y in K chunks of m words, where n = K*m, makes
+// them polynomials evaluated at b^m, and since b^(mK) = -1 their
+// product modulo 2^(n*_W)+1 is their product modulo X^K+1, which
+// is computed by NTransform and InvNTransform without a product
+// of double size.
+
+// negacyclicMinK is the smallest transform length used for
+// negacyclic products.
+const negacyclicMinK = 3
+
+// negacyclicSize returns the transform length k to compute products
+// modulo 2^(n*_W)+1 by negacyclic convolution, and false if n cannot
+// be cut in enough chunks.
+func (c *Config) negacyclicSize(n int) (k uint, ok bool) {
+	// Products modulo 2^(n*_W)+1 are cheaper than products of n
+	// words, and measurements show that the FFT length for products
+	// of n/4 words is best. Chunks must cut n exactly.
+	k, _ = c.fftSize(n / 4)
+	if tz := uint(bits.TrailingZeros(uint(n))); tz < k {
+		k = tz
+	}
+	return k, k >= negacyclicMinK
+}
+
+// recursive returns the environment used to compute pointwise
+// products of values of n words by negacyclic convolution, or nil
+// if they are too small. If useArena is false, buffers are not taken
+// from the arena of e, so that the environment can be used by other
+// goroutines. The memory it allocates is added to e by e.merge.
+func (e *env) recursive(n int, useArena bool) *env {
+	c := e.config()
+	if !c.recurses(n) {
+		return nil
+	}
+	sub := &env{cfg: c}
+	if e != nil {
+		sub.done = e.done
+		if useArena {
+			sub.arena = e.arena
+		}
+		if e.stats != nil || e.bytes != nil {
+			sub.bytes = new(int64)
+		}
+	}
+	return sub
+}
+
+// recurses reports whether pointwise products of values
+// of n words are computed by negacyclic convolution.
+func (c *Config) recurses(n int) bool {
+	if c.RecursionThreshold <= 0 || n < c.RecursionThreshold {
+		return false
+	}
+	_, ok := c.negacyclicSize(n)
+	return ok
+}
+
+// mulNegacyclic computes x*y, or x*x if y is nil, modulo 2^(n*_W)+1
+// where n = len(x)-1, and stores it in z which must have length n+1
+// and may alias x or y. n must be accepted by negacyclicSize.
+func (e *env) mulNegacyclic(z, x, y fermat) {
+	n := len(x) - 1
+	// -1 = 2^(n*_W) cannot be cut in chunks.
+	if x[n] != 0 || (y != nil && y[n] != 0) {
+		if y == nil {
+			for i := range z {
+				z[i] = 0
+			}
+			z[0] = 1
+			return
+		}
+		if x[n] == 0 {
+			x, y = y, x
+		}
+		// z may alias y.
+		mark := e.mark()
+		zero := fermat(e.nat(n + 1))
+		for i := range zero {
+			zero[i] = 0
+		}
+		z.Sub(zero, y)
+		e.free(mark)
+		return
+	}
+	k, _ := e.config().negacyclicSize(n)
+	m := n >> k
+	defer e.free(e.mark())
+	// Coefficients of the product are in (-K*b^(2m), K*b^(2m)).
+	np := valueSize(k, m, 0)
+	xp := e.polyFromNat(nat(x[:n]), k, m)
+	xp.a = xp.a[:len(xp.a)-1] // the last chunk is always zero.
+	xv := xp.NTransform(np)
+	var rv polValues
+	if y == nil {
+		rv = xv.Sqr()
+	} else {
+		yp := e.polyFromNat(nat(y[:n]), k, m)
+		yp.a = yp.a[:len(yp.a)-1]
+		yv := yp.NTransform(np)
+		rv = xv.Mul(&yv)
+	}
+	rp := rv.InvNTransform()
+
+	// Add positive and negative coefficients separately.
+	pos := fermat(e.nat(2*n + 1))
+	neg := fermat(e.nat(2*n + 1))
+	for i := range pos {
+		pos[i], neg[i] = 0, 0
+	}
+	abs := fermat(e.nat(np + 1))
+	for i, c := range rp.a {
+		c := fermat(c)
+		acc := pos
+		if c[np] != 0 || c[np-1]>>(_W-1) != 0 {
+			// c is more than 2^(np*_W-1), it represents c - 2^(np*_W) - 1.
+			for j := range abs {
+				abs[j] = 0
+			}
+			c = abs.Sub(abs, c)
+			acc = neg
+		}
+		c = fermat(trim(nat(c)))
+		if len(c) == 0 {
+			continue
+		}
+		a := acc[i*m:]
+		if carry := addVV(a[:len(c)], a[:len(c)], nat(c)); carry != 0 {
+			addVW(a[len(c):], a[len(c):], carry)
+		}
+	}
+	z.Sub(pos.reduce(n), neg.reduce(n))
+}
@@ -0,0 +1,63 @@
+package bigfft
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMulNegacyclic(t *testing.T) {
+	e := &env{arena: new(arena)}
+	for _, n := range []int{8, 16, 24, 64, 96, 256, 1 << 10, 3 << 10} {
+		for i := 0; i < 4; i++ {
+			x := make(fermat, n+1)
+			y := make(fermat, n+1)
+			copy(x, rndNat(n))
+			copy(y, rndNat(n))
+			switch i {
+			case 1:
+				x[n], x[0] = 1, 0 // -1
+				for j := range x[:n] {
+					x[j] = 0
+				}
+			case 2:
+				for j := range y[:n] {
+					y[j] = ^Word(0)
+				}
+			}
+			want := make(fermat, 8*n).Mul(x, y)
+			got := make(fermat, n+1)
+			mark := e.mark()
+			e.mulNegacyclic(got, x, y)
+			e.free(mark)
+			if cmpnat(t, nat(got), nat(want)) != 0 {
+				t.Errorf("n=%d: incorrect product %d", n, i)
+			}
+			want = make(fermat, 8*n).Sqr(x)
+			e.mulNegacyclic(got, x, nil)
+			if cmpnat(t, nat(got), nat(want)) != 0 {
+				t.Errorf("n=%d: incorrect square %d", n, i)
+			}
+		}
+	}
+}
+
+func BenchmarkMulNegacyclic(b *testing.B) {
+	for _, n := range []int{512, 1024, 4096} {
+		x := make(fermat, n+1)
+		y := make(fermat, n+1)
+		copy(x, rndNat(n))
+		copy(y, rndNat(n))
+		z := make(fermat, 8*n)
+		b.Run(fmt.Sprintf("big/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				z.Mul(x, y)
+			}
+		})
+		b.Run(fmt.Sprintf("fft/%d", n), func(b *testing.B) {
+			e := &env{arena: new(arena)}
+			for i := 0; i < b.N; i++ {
+				e.mulNegacyclic(z[:n+1], x, y)
+			}
+		})
+	}
+}
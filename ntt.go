@@ -0,0 +1,379 @@
+package bigfft
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Number-theoretic transforms modulo three primes.
+//
+// Products of medium size can also be computed as convolutions of
+// their words, modulo three primes p = c*2^s+1 of 63 bits. Each
+// convolution is computed by a transform of length L = 1<<k over
+// Z/pZ, which has roots of unity of order 2^s, and the coefficients
+// of the product, less than L*b^2 < p1*p2*p3, are recovered by the
+// Chinese remainder theorem and added with their carries.
+//
+// Residues are multiplied in Montgomery form: mul(a, b) = a*b/2^64
+// mod p. Vectors hold plain residues, while roots of unity are stored
+// multiplied by 2^64, so that mul(a, ω) = a*ω.
+
+// An nttPrime holds the constants of arithmetic
+// modulo a prime p = c*2^s+1 less than 2^63.
+type nttPrime struct {
+	p    uint64
+	s    uint   // p-1 = c*2^s with c odd.
+	g    uint64 // a non-square, whose powers have order 2^s.
+	pinv uint64 // -1/p mod 2^64.
+	r1   uint64 // 2^64 mod p, that is 1 in Montgomery form.
+	r2   uint64 // 2^128 mod p.
+
+	mu     sync.Mutex
+	roots  []uint64 // see rootsTo.
+	iroots []uint64
+}
+
+// nttPrimes are the primes of transforms, in increasing order.
+var nttPrimes = [3]*nttPrime{
+	newNTTPrime(0x4180000000000001, 55, 3),
+	newNTTPrime(0x5700000000000001, 56, 5),
+	newNTTPrime(0x6280000000000001, 55, 3),
+}
+
+// nttMaxK is the largest length 1<<nttMaxK of transforms,
+// the smallest s of nttPrimes.
+const nttMaxK = 55
+
+func newNTTPrime(p uint64, s uint, g uint64) *nttPrime {
+	q := &nttPrime{p: p, s: s, g: g}
+	// Newton iteration doubles the number of correct low bits
+	// of 1/p, starting with 3 bits since p*p = 1 mod 8.
+	inv := p
+	for i := 0; i < 5; i++ {
+		inv *= 2 - p*inv
+	}
+	q.pinv = -inv
+	q.r1 = -p % p
+	hi, lo := bits.Mul64(q.r1, q.r1)
+	_, q.r2 = bits.Div64(hi, lo, p)
+	return q
+}
+
+// mul returns a*b/2^64 mod p, for a, b less than p.
+func (q *nttPrime) mul(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	// Adding m*p, where m = -lo/p mod 2^64, clears the low word.
+	mh, ml := bits.Mul64(lo*q.pinv, q.p)
+	_, c := bits.Add64(lo, ml, 0)
+	return q.reduce(hi + mh + c - q.p)
+}
+
+// reduce returns a+p if a is negative as a signed number, else a.
+// It has no branch, which would be unpredictable on random residues.
+func (q *nttPrime) reduce(a uint64) uint64 {
+	return a + q.p&uint64(int64(a)>>63)
+}
+
+func (q *nttPrime) add(a, b uint64) uint64 {
+	return q.reduce(a + b - q.p)
+}
+
+func (q *nttPrime) sub(a, b uint64) uint64 {
+	return q.reduce(a - b)
+}
+
+// mont returns a*2^64 mod p, the Montgomery form of a.
+func (q *nttPrime) mont(a uint64) uint64 {
+	return q.mul(a, q.r2)
+}
+
+// pow returns a^e, where a and the result are in Montgomery form.
+func (q *nttPrime) pow(a, e uint64) uint64 {
+	r := q.r1
+	for ; e > 0; e >>= 1 {
+		if e&1 != 0 {
+			r = q.mul(r, a)
+		}
+		a = q.mul(a, a)
+	}
+	return r
+}
+
+// rootsTo returns the tables of roots of unity for transforms of
+// length up to 1<<k, in Montgomery form: roots[h+j] = ω^j and
+// iroots[h+j] = ω^-j for j < h, where ω is a root of order 2h.
+// Tables are computed once for the largest length.
+func (q *nttPrime) rootsTo(k uint) (roots, iroots []uint64) {
+	L := 1 << k
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.roots) < L {
+		roots, iroots := make([]uint64, L), make([]uint64, L)
+		g := q.mont(q.g)
+		for h := 1; h < L; h *= 2 {
+			e := (q.p - 1) / uint64(2*h)
+			w, iw := q.pow(g, e), q.pow(g, q.p-1-e)
+			x, ix := q.r1, q.r1
+			for j := 0; j < h; j++ {
+				roots[h+j], iroots[h+j] = x, ix
+				x, ix = q.mul(x, w), q.mul(ix, iw)
+			}
+		}
+		q.roots, q.iroots = roots, iroots
+	}
+	return q.roots[:L], q.iroots[:L]
+}
+
+// residues sets a to the words of x modulo p, followed by zeros.
+func (q *nttPrime) residues(a []uint64, x nat) {
+	for i, w := range x {
+		v := uint64(w)
+		for v >= q.p {
+			v -= q.p
+		}
+		a[i] = v
+	}
+	for i := len(x); i < len(a); i++ {
+		a[i] = 0
+	}
+}
+
+// forward computes the transform of a in place, leaving its
+// values in bit-reversed order.
+func (q *nttPrime) forward(a, roots []uint64) {
+	for h := len(a) / 2; h >= 2; h /= 2 {
+		w := roots[h : 2*h]
+		for i := 0; i < len(a); i += 2 * h {
+			x, y := a[i:i+h], a[i+h:i+2*h]
+			y, w = y[:len(x)], w[:len(x)]
+			for j, u := range x {
+				v := y[j]
+				x[j] = q.add(u, v)
+				y[j] = q.mul(q.sub(u, v), w[j])
+			}
+		}
+	}
+	// The last level has no twiddle factor.
+	for i := 0; i+1 < len(a); i += 2 {
+		u, v := a[i], a[i+1]
+		a[i], a[i+1] = q.add(u, v), q.sub(u, v)
+	}
+}
+
+// inverse computes the unnormalized inverse transform of a in
+// place, where a is in bit-reversed order, as left by forward.
+func (q *nttPrime) inverse(a, iroots []uint64) {
+	for i := 0; i+1 < len(a); i += 2 {
+		u, v := a[i], a[i+1]
+		a[i], a[i+1] = q.add(u, v), q.sub(u, v)
+	}
+	for h := 2; h < len(a); h *= 2 {
+		w := iroots[h : 2*h]
+		for i := 0; i < len(a); i += 2 * h {
+			x, y := a[i:i+h], a[i+h:i+2*h]
+			y, w = y[:len(x)], w[:len(x)]
+			for j, u := range x {
+				v := q.mul(y[j], w[j])
+				x[j] = q.add(u, v)
+				y[j] = q.sub(u, v)
+			}
+		}
+	}
+}
+
+// nttVectors holds a vector of residues for each of nttPrimes.
+type nttVectors [3][]uint64
+
+// nttVectors returns vectors of L residues for the transforms of
+// two operands, allocated like e.nat, or the same vectors twice
+// for a square.
+func (e *env) nttVectors(L int, square bool) (x, y nttVectors) {
+	for i := range x {
+		x[i] = e.uint64s(L)
+	}
+	if square {
+		return x, x
+	}
+	for i := range y {
+		y[i] = e.uint64s(L)
+	}
+	return x, y
+}
+
+// forward sets v[i] to the transform of x modulo nttPrimes[i]
+// for i from lo to hi.
+func (v nttVectors) forward(x nat, k uint, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		q := nttPrimes[i]
+		roots, _ := q.rootsTo(k)
+		q.residues(v[i], x)
+		q.forward(v[i], roots)
+	}
+}
+
+// mul multiplies v[i] by w[i] for i from lo to hi. w may be v.
+func (v nttVectors) mul(w nttVectors, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		q := nttPrimes[i]
+		b := w[i]
+		for j, u := range v[i] {
+			v[i][j] = q.mul(u, b[j])
+		}
+	}
+}
+
+// inverse computes the inverse transforms of v[i] for i from lo to
+// hi, and divides them by their length and by 2^64, left by the
+// Montgomery products of mul.
+func (v nttVectors) inverse(k uint, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		q := nttPrimes[i]
+		_, iroots := q.rootsTo(k)
+		q.inverse(v[i], iroots)
+		// 1/L = -(p-1)/L mod p, and mul(a, 2^128/L) = a*2^64/L.
+		f := q.mont(q.mont(q.p - (q.p-1)>>k))
+		for j, u := range v[i] {
+			v[i][j] = q.mul(u, f)
+		}
+	}
+}
+
+// nttCRT holds the constants of Garner's algorithm, which computes
+// the number v less than p1*p2*p3 with residues r1, r2, r3 as
+//
+//	v = r1 + p1*t2 + p1*p2*t3 where
+//	t2 = (r2-r1)/p1 mod p2 and t3 = (r3-r1-p1*t2)/(p1*p2) mod p3.
+var nttCRT = newNTTCRT()
+
+type nttCRTConsts struct {
+	inv1      uint64 // 1/p1 mod p2, in Montgomery form.
+	p1        uint64 // p1 mod p3, in Montgomery form.
+	inv12     uint64 // 1/(p1*p2) mod p3, in Montgomery form.
+	p12, p12l uint64 // the high and low words of p1*p2.
+}
+
+func newNTTCRT() nttCRTConsts {
+	q1, q2, q3 := nttPrimes[0], nttPrimes[1], nttPrimes[2]
+	var c nttCRTConsts
+	// Inverses are computed as a^(p-2).
+	c.inv1 = q2.pow(q2.mont(q1.p), q2.p-2)
+	c.p1 = q3.mont(q1.p)
+	c.p12, c.p12l = bits.Mul64(q1.p, q2.p)
+	_, p12 := bits.Div64(c.p12%q3.p, c.p12l, q3.p)
+	c.inv12 = q3.pow(q3.mont(p12), q3.p-2)
+	return c
+}
+
+// crt returns the words of the number v with residues r1, r2, r3.
+func (c *nttCRTConsts) crt(r1, r2, r3 uint64) (v0, v1, v2 uint64) {
+	q2, q3 := nttPrimes[1], nttPrimes[2]
+	t2 := q2.mul(q2.sub(r2, r1), c.inv1)
+	u := q3.sub(q3.sub(r3, r1), q3.mul(t2, c.p1))
+	t3 := q3.mul(u, c.inv12)
+	// p1*t2 + r1
+	h, l := bits.Mul64(nttPrimes[0].p, t2)
+	l, carry := bits.Add64(l, r1, 0)
+	h += carry
+	// p1*p2*t3
+	ah, al := bits.Mul64(c.p12l, t3)
+	bh, bl := bits.Mul64(c.p12, t3)
+	w1, carry := bits.Add64(ah, bl, 0)
+	w2 := bh + carry
+	v0, carry = bits.Add64(al, l, 0)
+	v1, carry = bits.Add64(w1, h, carry)
+	v2 = w2 + carry
+	return
+}
+
+// nttWords is the number of words of the values of coefficients
+// recovered by nttCRT.
+const nttWords = 192 / _W
+
+// nttmulTo computes x*y, or x*x if y is nil, by number-theoretic
+// transforms, using the storage of z for the result if its capacity
+// is large enough.
+func nttmulTo(e *env, z, x, y nat) nat {
+	ylen := len(x)
+	if y != nil {
+		ylen = len(y)
+	}
+	// The product has len(x)+ylen-1 coefficients.
+	k := uint(bits.Len(uint(len(x) + ylen - 2)))
+	if k > nttMaxK {
+		panic("bigfft: product too large for number-theoretic transforms")
+	}
+	L := 1 << k
+	e.setNTTSize(k)
+	defer e.free(e.mark())
+	t := e.clock()
+	xr, yr := e.nttVectors(L, y == nil)
+	if e.parallel() {
+		e.parallelRange(3, L, func(lo, hi int) {
+			xr.forward(x, k, lo, hi)
+			if y != nil {
+				yr.forward(y, k, lo, hi)
+			}
+		})
+	} else {
+		xr.forward(x, k, 0, 3)
+		if y != nil {
+			yr.forward(y, k, 0, 3)
+		}
+	}
+	if e.cancelled() {
+		return nil
+	}
+	t = e.lap(t, stageForward)
+	if e.parallel() {
+		e.parallelRange(3, L, func(lo, hi int) { xr.mul(yr, lo, hi) })
+	} else {
+		xr.mul(yr, 0, 3)
+	}
+	t = e.lap(t, stagePointwise)
+	if e.parallel() {
+		e.parallelRange(3, L, func(lo, hi int) { xr.inverse(k, lo, hi) })
+	} else {
+		xr.inverse(k, 0, 3)
+	}
+	if e.cancelled() {
+		return nil
+	}
+	t = e.lap(t, stageInverse)
+	defer e.lap(t, stageRecompose)
+
+	length := len(x) + ylen
+	// x and y are no longer used, so z may alias them.
+	if cap(z) < length {
+		z = make(nat, length)
+		e.allocated(int64(length) * wordBytes)
+	}
+	z = z[:length]
+	// acc holds the words of the product from index i,
+	// to which later coefficients are added.
+	acc := e.nat(nttWords + 1)
+	for j := range acc {
+		acc[j] = 0
+	}
+	buf := e.nat(nttWords)
+	for i := range z {
+		if i < L {
+			v0, v1, v2 := nttCRT.crt(xr[0][i], xr[1][i], xr[2][i])
+			for j, v := range [3]uint64{v0, v1, v2} {
+				for b := 0; b < 64/_W; b++ {
+					buf[j*64/_W+b] = Word(v >> uint(b*_W))
+				}
+			}
+			acc[nttWords] += addVV(acc[:nttWords], acc[:nttWords], buf)
+		}
+		z[i] = acc[0]
+		copy(acc, acc[1:])
+		acc[nttWords] = 0
+	}
+	return trim(z)
+}
+
+// useNTT reports whether a product of the given number of words
+// is computed by number-theoretic transforms.
+func (c *Config) useNTT(words int) bool {
+	return words <= c.NTTThreshold && bits.Len(uint(words)) <= nttMaxK
+}
@@ -0,0 +1,107 @@
+package bigfft
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestNTTPrimes(t *testing.T) {
+	for _, q := range nttPrimes {
+		p := new(big.Int).SetUint64(q.p)
+		if !p.ProbablyPrime(20) || q.p>>63 != 0 {
+			t.Errorf("%#x is not a prime of 63 bits", q.p)
+		}
+		if (q.p-1)>>q.s<<q.s != q.p-1 || (q.p-1)>>q.s&1 == 0 || q.s < nttMaxK {
+			t.Errorf("%#x is not c*2^%d+1 with c odd", q.p, q.s)
+		}
+		// Powers of g have order 2^s if g is not a square, since c is odd.
+		g := q.mont(q.g)
+		if q.pow(g, (q.p-1)/2) != q.p-q.r1 {
+			t.Errorf("%d is a square modulo %#x", q.g, q.p)
+		}
+		if a, b := q.mont(12345), q.mont(67890); q.mul(a, b) != q.mont(12345*67890%q.p) {
+			t.Errorf("incorrect Montgomery product modulo %#x", q.p)
+		}
+		roots, iroots := q.rootsTo(10)
+		for h := 1; h < len(roots); h *= 2 {
+			w := q.pow(g, (q.p-1)/2)
+			if h > 1 {
+				w = roots[h+1]
+			}
+			if q.pow(w, uint64(2*h)) != q.r1 || q.pow(w, uint64(h)) == q.r1 {
+				t.Errorf("%#x: root of order %d is incorrect", q.p, 2*h)
+			}
+			for j := 0; j < h; j++ {
+				if q.mul(roots[h+j], iroots[h+j]) != q.r1 {
+					t.Errorf("%#x: incorrect inverse root %d of order %d", q.p, j, 2*h)
+				}
+			}
+		}
+	}
+}
+
+func TestNTTCRT(t *testing.T) {
+	p := big.NewInt(1)
+	for _, q := range nttPrimes {
+		p.Mul(p, new(big.Int).SetUint64(q.p))
+	}
+	v := new(big.Int)
+	for i := 0; i < 100; i++ {
+		v.Rand(rnd, p)
+		if i == 0 {
+			v.Sub(p, big.NewInt(1))
+		}
+		var r [3]uint64
+		for j, q := range nttPrimes {
+			r[j] = new(big.Int).Mod(v, new(big.Int).SetUint64(q.p)).Uint64()
+		}
+		v0, v1, v2 := nttCRT.crt(r[0], r[1], r[2])
+		got := new(big.Int).SetUint64(v2)
+		got.Lsh(got, 64).Or(got, new(big.Int).SetUint64(v1))
+		got.Lsh(got, 64).Or(got, new(big.Int).SetUint64(v0))
+		if got.Cmp(v) != 0 {
+			t.Errorf("crt(%v) = %s, want %s", r, got, v)
+		}
+	}
+}
+
+func TestNTTMul(t *testing.T) {
+	// Buffers reused from an arena have unspecified contents.
+	e := &env{arena: new(arena)}
+	for _, s := range [][2]int{
+		{1, 1}, {2, 1}, {3, 3}, {100, 28}, {1000, 1000}, {4097, 4095}, {30000, 1000},
+	} {
+		x, y := rndNat(s[0]), rndNat(s[1])
+		if got, want := nttmulTo(e, nil, x, y), basicMulNat(x, y); cmpnat(t, got, want) != 0 {
+			t.Errorf("sizes %v: incorrect product", s)
+		}
+		if got, want := nttmulTo(e, nil, x, nil), basicMulNat(x, x); cmpnat(t, got, want) != 0 {
+			t.Errorf("size %d: incorrect square", s[0])
+		}
+	}
+	// Maximal words give the largest coefficients.
+	x := make(nat, 5000)
+	for i := range x {
+		x[i] = ^Word(0)
+	}
+	if got, want := nttmulTo(e, nil, x, x), basicMulNat(x, x); cmpnat(t, got, want) != 0 {
+		t.Errorf("incorrect product of maximal words")
+	}
+}
+
+func BenchmarkNTT(b *testing.B) {
+	for _, n := range []int{2e3, 5e3, 1e4, 3e4, 1e5, 3e5} {
+		x, y := rndNat(n), rndNat(n)
+		b.Run(fmt.Sprintf("fft/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fftmul(x, y)
+			}
+		})
+		b.Run(fmt.Sprintf("ntt/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				nttmulTo(nil, nil, x, y)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package bigfft
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// Product computes the product of all elements of xs and returns it.
+// The product of an empty slice is 1.
+//
+// Factors are multiplied along a balanced tree, where each node
+// splits its factors in two halves of about the same total size,
+// so that large products are computed by FFT on operands of
+// similar sizes.
+func Product(xs []*big.Int) *big.Int {
+	return ProductParallel(xs, 1)
+}
+
+// ProductParallel is like Product but computes independent
+// subtrees of the product tree in parallel, using at most
+// procs goroutines.
+func ProductParallel(xs []*big.Int, procs int) *big.Int {
+	if len(xs) == 0 {
+		return big.NewInt(1)
+	}
+	// sums[i] is the total size of xs[:i].
+	sums := make([]int, len(xs)+1)
+	for i, x := range xs {
+		sums[i+1] = sums[i] + len(x.Bits())
+	}
+	t := prodTree{xs: xs, sums: sums}
+	if procs > 1 {
+		t.sem = make(chan struct{}, procs-1)
+	}
+	return t.prod(0, len(xs))
+}
+
+// prodParallelThreshold is the size (in words) of a subproduct
+// above which it may be computed in a separate goroutine.
+const prodParallelThreshold = 1 << 10
+
+type prodTree struct {
+	xs   []*big.Int
+	sums []int
+	sem  chan struct{} // tokens for extra goroutines, nil if serial.
+}
+
+// prod returns the product of xs[i:j].
+func (t *prodTree) prod(i, j int) *big.Int {
+	switch j - i {
+	case 1:
+		return new(big.Int).Set(t.xs[i])
+	case 2:
+		return Mul(t.xs[i], t.xs[i+1])
+	}
+	// Split xs[i:j] where the partial sum of sizes
+	// is closest to half of the total.
+	half := (t.sums[i] + t.sums[j]) / 2
+	mid := i + sort.SearchInts(t.sums[i:j], half)
+	if mid > i+1 && half-t.sums[mid-1] < t.sums[mid]-half {
+		mid--
+	}
+	if mid <= i {
+		mid = i + 1
+	} else if mid >= j {
+		mid = j - 1
+	}
+
+	var left, right *big.Int
+	if t.sem != nil && t.sums[j]-t.sums[i] > prodParallelThreshold {
+		select {
+		case t.sem <- struct{}{}:
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				left = t.prod(i, mid)
+				<-t.sem
+				wg.Done()
+			}()
+			right = t.prod(mid, j)
+			wg.Wait()
+			return Mul(left, right)
+		default:
+		}
+	}
+	left = t.prod(i, mid)
+	right = t.prod(mid, j)
+	return Mul(left, right)
+}
@@ -0,0 +1,65 @@
+package bigfft
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestProduct(t *testing.T) {
+	if p := Product(nil); p.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("empty product is %s, expected 1", p)
+	}
+
+	// Factors of very different sizes.
+	var xs []*big.Int
+	for i := 0; i < 300; i++ {
+		size := 1 + i%7
+		if i%50 == 0 {
+			size = 3000
+		}
+		x := new(big.Int).SetBits(rndNat(size))
+		if i%3 == 0 {
+			x.Neg(x)
+		}
+		xs = append(xs, x)
+	}
+	want := big.NewInt(1)
+	for _, x := range xs {
+		want.Mul(want, x)
+	}
+	if p := Product(xs); p.Cmp(want) != 0 {
+		t.Errorf("incorrect product of %d factors", len(xs))
+	}
+	if p := ProductParallel(xs, 4); p.Cmp(want) != 0 {
+		t.Errorf("incorrect parallel product of %d factors", len(xs))
+	}
+	if p := Product(xs[:1]); p.Cmp(xs[0]) != 0 || p == xs[0] {
+		t.Errorf("product of one factor must be a copy of it")
+	}
+}
+
+func TestProductFactorial(t *testing.T) {
+	const n = 20000
+	xs := make([]*big.Int, n)
+	for i := range xs {
+		xs[i] = big.NewInt(int64(i + 1))
+	}
+	want := new(big.Int).MulRange(1, n)
+	if p := ProductParallel(xs, 8); p.Cmp(want) != 0 {
+		t.Errorf("incorrect value of %d!", n)
+	}
+}
+
+func benchmarkProduct(b *testing.B, n, procs int) {
+	xs := make([]*big.Int, n)
+	for i := range xs {
+		xs[i] = new(big.Int).SetBits(rndNat(64))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ProductParallel(xs, procs)
+	}
+}
+
+func BenchmarkProduct_10k(b *testing.B)   { benchmarkProduct(b, 10000, 1) }
+func BenchmarkProduct_10k_4(b *testing.B) { benchmarkProduct(b, 10000, 4) }
@@ -0,0 +1,157 @@
+package bigfft
+
+import (
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes how a multiplication was computed.
+type Stats struct {
+	// FFT reports whether the product was computed by FFT.
+	// If it is false, math/big was used and only Total is set.
+	FFT bool
+
+	// K is the FFT length, M the number of words of each chunk
+	// of the operands and N the number of words of the values
+	// of the transforms, which are computed modulo 2^(N*W)+1.
+	K, M, N int
+	// Blocks is the number of blocks the longer operand was cut
+	// into. It is 1 unless operands are very unbalanced.
+	Blocks int
+	// NTT reports whether the transforms were number-theoretic
+	// transforms of words modulo three primes of 63 bits, in
+	// which case M and N are 1.
+	NTT bool
+	// Float reports whether the transforms were floating-point
+	// FFTs of 16-bit limbs, in which case M and N are 0.
+	Float bool
+
+	// Bytes is the amount of memory allocated for transforms
+	// and for the result.
+	Bytes int64
+
+	// Time spent in each stage of the multiplication: forward
+	// transforms, pointwise products, inverse transforms, and
+	// recomposition of the result from its coefficients.
+	Forward, Pointwise, Inverse, Recompose time.Duration
+	// Total is the duration of the whole multiplication.
+	Total time.Duration
+}
+
+// MulWithStats computes the product x*y and returns it, like Mul,
+// together with statistics about the computation.
+func MulWithStats(x, y *big.Int) (*big.Int, Stats) {
+	return defaultMultiplier.MulWithStats(x, y)
+}
+
+// MulWithStats computes the product x*y and returns it, like m.Mul,
+// together with statistics about the computation. Timing stages
+// makes it slightly slower than m.Mul.
+func (m *Multiplier) MulWithStats(x, y *big.Int) (*big.Int, Stats) {
+	var stats Stats
+	t0 := time.Now()
+	z := new(big.Int)
+	if m.useTransform(len(x.Bits()), len(y.Bits())) {
+		e := m.env()
+		e.stats = &stats
+		stats.FFT = true
+		mulFFTTo(e, z, x, y)
+	} else {
+		z.Mul(x, y)
+	}
+	stats.Total = time.Since(t0)
+	return z, stats
+}
+
+// A stage is a step of a FFT multiplication.
+type stage int
+
+const (
+	stageForward stage = iota
+	stagePointwise
+	stageInverse
+	stageRecompose
+)
+
+// clock returns the current time if e collects statistics.
+func (e *env) clock() time.Time {
+	if e == nil || e.stats == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// lap adds the time elapsed since t to stage s
+// and returns the current time.
+func (e *env) lap(t time.Time, s stage) time.Time {
+	if e == nil || e.stats == nil {
+		return t
+	}
+	now := time.Now()
+	d := now.Sub(t)
+	switch s {
+	case stageForward:
+		e.stats.Forward += d
+	case stagePointwise:
+		e.stats.Pointwise += d
+	case stageInverse:
+		e.stats.Inverse += d
+	case stageRecompose:
+		e.stats.Recompose += d
+	}
+	return now
+}
+
+// setSize records the parameters of the transforms
+// and the number of blocks.
+func (e *env) setSize(k uint, m, blocks int) {
+	if e == nil || e.stats == nil {
+		return
+	}
+	e.stats.K = 1 << k
+	e.stats.M = m
+	e.stats.N = valueSize(k, m, 2)
+	e.stats.Blocks = blocks
+}
+
+// setNTTSize records the length 1<<k of number-theoretic transforms.
+func (e *env) setNTTSize(k uint) {
+	if e == nil || e.stats == nil {
+		return
+	}
+	e.stats.K = 1 << k
+	e.stats.M, e.stats.N, e.stats.Blocks = 1, 1, 1
+	e.stats.NTT = true
+}
+
+// setFloatSize records the length 1<<k of floating-point transforms.
+func (e *env) setFloatSize(k uint) {
+	if e == nil || e.stats == nil {
+		return
+	}
+	e.stats.K = 1 << k
+	e.stats.M, e.stats.N, e.stats.Blocks = 0, 0, 1
+	e.stats.Float = true
+}
+
+// allocated records the allocation of the given number of bytes.
+func (e *env) allocated(bytes int64) {
+	if e == nil {
+		return
+	}
+	if e.bytes != nil {
+		atomic.AddInt64(e.bytes, bytes)
+	} else if e.stats != nil {
+		e.stats.Bytes += bytes
+	}
+}
+
+// merge adds the memory allocated by sub, an environment
+// returned by e.recursive, to the memory allocated by e.
+func (e *env) merge(sub *env) {
+	if sub == nil || sub.bytes == nil {
+		return
+	}
+	e.allocated(atomic.LoadInt64(sub.bytes))
+}
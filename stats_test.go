@@ -0,0 +1,73 @@
+package bigfft
+
+import (
+	"testing"
+)
+
+func TestMulWithStats(t *testing.T) {
+	tests := []struct {
+		xsize, ysize int
+		fft          bool
+		unbalanced   bool
+	}{
+		{50e3, 50e3, false, false},
+		{200e3, 300e3, true, false},
+		{1e6, 1e6, true, false},
+		{20e6, 200e3, true, true},
+	}
+	for _, tt := range tests {
+		var x, y Int
+		x.SetBits(rndNat(tt.xsize / _W))
+		y.SetBits(rndNat(tt.ysize / _W))
+		y.Neg(&y)
+		z, stats := MulWithStats(&x, &y)
+		if want := new(Int).Mul(&x, &y); z.Cmp(want) != 0 {
+			t.Errorf("incorrect product for sizes %d, %d", tt.xsize, tt.ysize)
+		}
+		t.Logf("sizes %d, %d: %+v", tt.xsize, tt.ysize, stats)
+		if stats.FFT != tt.fft {
+			t.Errorf("sizes %d, %d: got FFT=%v, expected %v", tt.xsize, tt.ysize, stats.FFT, tt.fft)
+		}
+		if stats.Total <= 0 {
+			t.Errorf("sizes %d, %d: total time is not set", tt.xsize, tt.ysize)
+		}
+		if !stats.FFT {
+			continue
+		}
+		if (stats.Blocks > 1) != tt.unbalanced {
+			t.Errorf("sizes %d, %d: unexpected number of blocks %d", tt.xsize, tt.ysize, stats.Blocks)
+		}
+		if stats.M*stats.K <= (tt.xsize+tt.ysize)/_W/stats.Blocks {
+			t.Errorf("sizes %d, %d: K=%d and M=%d are too small", tt.xsize, tt.ysize, stats.K, stats.M)
+		}
+		if stats.N < 2*stats.M {
+			t.Errorf("sizes %d, %d: N=%d is too small for M=%d", tt.xsize, tt.ysize, stats.N, stats.M)
+		}
+		if stats.Bytes < int64(tt.xsize+tt.ysize)/8 {
+			t.Errorf("sizes %d, %d: %d bytes is less than the size of the result",
+				tt.xsize, tt.ysize, stats.Bytes)
+		}
+		stages := stats.Forward + stats.Pointwise + stats.Inverse + stats.Recompose
+		if stats.Forward <= 0 || stats.Pointwise <= 0 || stats.Inverse <= 0 || stages > stats.Total {
+			t.Errorf("sizes %d, %d: inconsistent stage timings", tt.xsize, tt.ysize)
+		}
+	}
+}
+
+func TestMulWithStatsNTT(t *testing.T) {
+	m := NewMultiplier(Config{NTTThreshold: 1 << 20})
+	var x, y Int
+	x.SetBits(rndNat(200e3 / _W))
+	y.SetBits(rndNat(300e3 / _W))
+	z, stats := m.MulWithStats(&x, &y)
+	if want := new(Int).Mul(&x, &y); z.Cmp(want) != 0 {
+		t.Errorf("incorrect product")
+	}
+	t.Logf("%+v", stats)
+	if !stats.FFT || !stats.NTT || stats.K < len(z.Bits()) {
+		t.Errorf("unexpected stats %+v for number-theoretic transforms", stats)
+	}
+	if stats.Forward <= 0 || stats.Inverse <= 0 || stats.Recompose <= 0 {
+		t.Errorf("inconsistent stage timings")
+	}
+}
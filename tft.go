@@ -0,0 +1,226 @@
+package bigfft
+
+import (
+	"math/bits"
+)
+
+// Truncated Fourier transforms.
+//
+// A product of polynomials with L coefficients, where K/2 < L < K,
+// is determined by its values at L of the K-th roots of unity.
+// Taking roots in bit-reversed order, the first L roots split in
+// blocks of decreasing power-of-two sizes, following the binary
+// digits of L. The roots of a block of size h starting at index s
+// are the roots of X^h - c where c = ω^(h·rev(s)), and the values
+// at these roots are given by a cyclic transform of length h of
+// the residue modulo X^h - c, twisted by ζ = ω^rev(s).
+//
+// Residues modulo each block are obtained by descending the tree
+// of factors X^2h - c² = (X^h - c)(X^h + c), which only needs
+// butterflies along a path of the tree. The product is recovered
+// from its residues modulo each block, since all factors of later
+// blocks divide X^h + c, and X^h - c = -2c modulo them.
+
+// tftMaxBlocks is the maximal number of blocks of a truncated
+// transform. The number of coefficients is rounded up to have
+// at most tftMaxBlocks binary digits set.
+const tftMaxBlocks = 3
+
+// tftLength rounds length up to a valid length for
+// a truncated transform.
+func tftLength(length int) int {
+	for bits.OnesCount(uint(length)) > tftMaxBlocks {
+		length += length & -length
+	}
+	return length
+}
+
+// tftBlock returns the size of the block of a truncated transform
+// of the given length, starting at index s.
+func tftBlock(s, length int) int {
+	return 1 << uint(bits.Len(uint(length-s))-1)
+}
+
+// rev returns the k-bit reversal of s.
+func rev(s int, k uint) int {
+	return int(bits.Reverse(uint(s)) >> (bits.UintSize - k))
+}
+
+// rootShift returns the argument of fermat.ShiftHalf
+// multiplying by ω^t·2^s, where ω = 2^(ω2shift/2) is
+// a K-th root of unity.
+func rootShift(t, s int, k uint, ω2shift int) int {
+	t &= 1<<k - 1
+	return t*ω2shift + 2*s
+}
+
+// mulTruncated computes p*q, or p*p if q is nil, using truncated
+// transforms of the given length, which must be larger than the
+// number of coefficients of the product.
+func (p *poly) mulTruncated(q *poly, n, length int) poly {
+	e := p.env
+	pv := polValues{p.k, n, e.tftForward(p.a, p.k, n, length), e}
+	if e.cancelled() {
+		return poly{}
+	}
+	var rv polValues
+	if q == nil {
+		rv = pv.Sqr()
+	} else {
+		qv := polValues{p.k, n, e.tftForward(q.a, p.k, n, length), e}
+		if e.cancelled() {
+			return poly{}
+		}
+		rv = pv.Mul(&qv)
+	}
+	if e.cancelled() {
+		return poly{}
+	}
+	return poly{k: p.k, m: p.m, a: e.tftInverse(rv.values, p.k, n), env: e}
+}
+
+// tftForward evaluates the polynomial with coefficients a at the
+// first length K-th roots of unity in bit-reversed order. Values of
+// each block are in the order of a cyclic transform.
+func (e *env) tftForward(a []nat, k uint, n, length int) []fermat {
+	defer e.lap(e.clock(), stageForward)
+	ω2shift := (4 * n * _W) >> k
+	values := e.vector(length, n)
+	mark := e.mark()
+	r := e.values(k, n)
+	tmp := fermat(e.nat(n + 1))
+	tmp2 := fermat(e.nat(n + 1))
+	// Coefficients of r at index top and above are zero.
+	top := len(a)
+	for i := 0; i < top; i++ {
+		l := copy(r[i], a[i])
+		for j := l; j < n+1; j++ {
+			r[i][j] = 0
+		}
+	}
+	// r[s:s+h] holds the residue modulo the current node.
+	s, h := 0, 1<<k
+	for s < length {
+		b := tftBlock(s, length)
+		for h > b {
+			// Split the node of length 2h.
+			h /= 2
+			c := rootShift(h*rev(s, k), 0, k, ω2shift)
+			both := b == h && s+h < length
+			for i := 0; i < h; i++ {
+				x, y := r[s+i], r[s+h+i]
+				switch {
+				case s+h+i < top:
+					tmp.ShiftHalf(y, c, tmp2)
+					if both {
+						y.Sub(x, tmp)
+					}
+					x.Add(x, tmp)
+				case s+i < top:
+					if both {
+						copy(y, x)
+					}
+				case both:
+					for j := range y {
+						x[j], y[j] = 0, 0
+					}
+				}
+			}
+			if both {
+				top = s + 2*h
+			} else if top > s+h {
+				top = s + h
+			}
+		}
+		// Twist the block by ζ and transform it.
+		ζ := rev(s, k)
+		for i := 1; i < b && s+i < top; i++ {
+			tmp.ShiftHalf(r[s+i], rootShift(ζ*i, 0, k, ω2shift), tmp2)
+			copy(r[s+i], tmp)
+		}
+		for i := b; i > 0 && s+i > top; i-- {
+			x := r[s+i-1]
+			for j := range x {
+				x[j] = 0
+			}
+		}
+		e.fourier(values[s:s+b], r[s:s+b], false, n, uint(bits.Len(uint(b))-1))
+		if e.cancelled() {
+			break
+		}
+		s += b
+	}
+	e.free(mark)
+	return values
+}
+
+// tftInverse reconstructs the polynomial with len(v) coefficients
+// from its values computed by tftForward.
+func (e *env) tftInverse(v []fermat, k uint, n int) []nat {
+	defer e.lap(e.clock(), stageInverse)
+	length := len(v)
+	ω2shift := (4 * n * _W) >> k
+	r := e.vector(length, n)
+	a := e.nats(length)
+	mark := e.mark()
+	tmp := fermat(e.nat(n + 1))
+	tmp2 := fermat(e.nat(n + 1))
+	for s := 0; s < length; {
+		b := tftBlock(s, length)
+		lb := uint(bits.Len(uint(b)) - 1)
+		e.fourier(r[s:s+b], v[s:s+b], true, n, lb)
+		if e.cancelled() {
+			break
+		}
+		// Divide by b and untwist by ζ to obtain
+		// the residue modulo X^b - c.
+		ζ := rev(s, k)
+		for i := 0; i < b; i++ {
+			tmp.ShiftHalf(r[s+i], rootShift(-ζ*i, -int(lb), k, ω2shift), tmp2)
+			copy(r[s+i], tmp)
+		}
+		// The product is P = r1 + M1·(r2 + M2·(r3 + ...)) where
+		// Mi = X^bi - ci. Compute the residue ri of block i by
+		// removing earlier terms, modulo X^b - c where Mi = -2ci.
+		c := b * ζ
+		for sl := 0; sl < s; {
+			bl := tftBlock(sl, length)
+			for u := 0; u < bl; u++ {
+				x := r[s+u%b]
+				tmp.ShiftHalf(r[sl+u], rootShift(c*(u/b), 0, k, ω2shift), tmp2)
+				x.Sub(x, tmp)
+			}
+			// Divide by -2cl.
+			div := rootShift(-bl*rev(sl, k), n*_W-1, k, ω2shift)
+			for i := 0; i < b; i++ {
+				tmp.ShiftHalf(r[s+i], div, tmp2)
+				copy(r[s+i], tmp)
+			}
+			sl += bl
+		}
+		s += b
+	}
+	// Evaluate the nested products, starting from the last block.
+	// Multiplying by X^b - c only needs to subtract c times
+	// the higher coefficients.
+	var starts [bits.UintSize]int
+	blocks := 0
+	for s := 0; s < length; s += tftBlock(s, length) {
+		starts[blocks] = s
+		blocks++
+	}
+	for j := blocks - 2; j >= 0; j-- {
+		s := starts[j]
+		b := tftBlock(s, length)
+		c := rootShift(b*rev(s, k), 0, k, ω2shift)
+		for i := 0; s+b+i < length; i++ {
+			tmp.ShiftHalf(r[s+b+i], c, tmp2)
+			r[s+i].Sub(r[s+i], tmp)
+		}
+	}
+	for i := range a {
+		a[i] = nat(r[i])
+	}
+	e.free(mark)
+	return a
+}
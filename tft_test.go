@@ -0,0 +1,85 @@
+package bigfft
+
+import (
+	"testing"
+)
+
+func TestTFTLength(t *testing.T) {
+	for length := 1; length < 5000; length++ {
+		l := tftLength(length)
+		if l < length || l > length+length/(1<<(tftMaxBlocks-1)) {
+			t.Errorf("tftLength(%d) = %d", length, l)
+		}
+		blocks := 0
+		for s := 0; s < l; s += tftBlock(s, l) {
+			blocks++
+		}
+		if blocks > tftMaxBlocks {
+			t.Errorf("tftLength(%d) = %d has %d blocks", length, l, blocks)
+		}
+	}
+}
+
+func TestMulTruncated(t *testing.T) {
+	// Buffers reused from an arena have unspecified contents.
+	e := &env{arena: new(arena)}
+	for k := uint(2); k <= 8; k++ {
+		K := 1 << k
+		m := 3
+		n := valueSize(k, m, 2)
+		for _, lens := range [][2]int{
+			{K/2 + 1, 1}, {K / 2, K/4 + 1}, {K/4 + 3, K/4 + 2}, {K - 1, 1},
+			{K / 2, K/2 - 1}, {K/4 + 1, 1}, {K/3 + 1, K/5 + 1},
+		} {
+			x := rndNat(lens[0]*m - 1)
+			y := rndNat(lens[1]*m - 1)
+			mark := e.mark()
+			xp, yp := e.polyFromNat(x, k, m), e.polyFromNat(y, k, m)
+			length := tftLength(len(xp.a) + len(yp.a) - 1)
+			if length > K {
+				e.free(mark)
+				continue
+			}
+			want := basicMulNat(x, y)
+			rp := xp.mulTruncated(&yp, n, length)
+			if got := rp.Int(); cmpnat(t, got, want) != 0 {
+				t.Errorf("k=%d, lengths %v: incorrect product with truncated length %d", k, lens, length)
+			}
+			if tftLength(2*len(xp.a)-1) > K {
+				e.free(mark)
+				continue
+			}
+			rp = xp.mulTruncated(nil, n, tftLength(2*len(xp.a)-1))
+			if got, want := rp.Int(), basicMulNat(x, x); cmpnat(t, got, want) != 0 {
+				t.Errorf("k=%d, length %d: incorrect truncated square", k, lens[0])
+			}
+			e.free(mark)
+		}
+	}
+}
+
+// Products just above a size where the FFT length changes
+// must not be much slower than below.
+func BenchmarkMulFFT_Sizes(b *testing.B) {
+	for _, bits := range []int{1.6e6, 1.77e6, 1.9e6, 16e6, 17.4e6, 19e6} {
+		b.Run(sprintBits(bits), func(b *testing.B) {
+			x := rndNat(bits / _W)
+			y := rndNat(bits / _W)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fftmul(x, y)
+			}
+		})
+	}
+}
+
+func basicMulNat(x, y nat) nat {
+	var xi, yi Int
+	xi.SetBits(x)
+	yi.SetBits(y)
+	return new(Int).Mul(&xi, &yi).Bits()
+}
+
+func sprintBits(bits int) string {
+	return nat{Word(bits)}.String() + "bits"
+}
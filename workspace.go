@@ -0,0 +1,223 @@
+package bigfft
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// A Workspace holds the buffers used by FFT multiplications, so that
+// repeated multiplications of similar sizes do not allocate memory
+// once the buffers have been allocated by a first multiplication.
+//
+// Buffers are kept until Reset is called, and are only reused by
+// requests of at least a quarter of their size: after multiplications
+// of various sizes, a Workspace holds buffers for each of them, which
+// can amount to several times the memory of the largest one.
+//
+// The zero value is a Workspace using the default configuration.
+// A Workspace must not be used by several goroutines at the same
+// time, but it can be shared through a sync.Pool.
+type Workspace struct {
+	m     *Multiplier
+	arena arena
+	env   env
+}
+
+// NewWorkspace returns a Workspace for multiplications
+// using the configuration of m.
+func (m *Multiplier) NewWorkspace() *Workspace {
+	return &Workspace{m: m}
+}
+
+// Reset releases the buffers held by w.
+func (w *Workspace) Reset() {
+	w.arena = arena{}
+}
+
+func (w *Workspace) multiplier() *Multiplier {
+	if w.m == nil {
+		return defaultMultiplier
+	}
+	return w.m
+}
+
+// setup prepares the execution environment of a multiplication.
+func (w *Workspace) setup(m *Multiplier) *env {
+	sem := w.env.sem
+	if procs := m.cfg.Parallelism; procs > 1 && cap(sem) != procs-1 {
+		sem = make(chan struct{}, procs-1)
+	} else if procs <= 1 {
+		sem = nil
+	}
+	w.env = env{sem: sem, cfg: &m.cfg, arena: &w.arena}
+	return &w.env
+}
+
+// MulTo computes the product x*y, stores it in z and returns z.
+// Like the Mul method of *big.Int, it reuses the storage of z
+// when it is large enough, and z may alias x or y.
+//
+// Transforms use the buffers of w. When multiplications run serially,
+// MulTo does not allocate memory if w and z are large enough.
+func (w *Workspace) MulTo(z, x, y *big.Int) *big.Int {
+	m := w.multiplier()
+	if m.useTransform(len(x.Bits()), len(y.Bits())) {
+		return mulFFTTo(w.setup(m), z, x, y)
+	}
+	return z.Mul(x, y)
+}
+
+// SqrTo computes the square x*x, stores it in z and returns z.
+// It reuses the storage of z when it is large enough, and z may
+// alias x. Like MulTo, it does not allocate memory if w and z
+// are large enough.
+func (w *Workspace) SqrTo(z, x *big.Int) *big.Int {
+	m := w.multiplier()
+	xwords := len(x.Bits())
+	if m.useTransform(xwords, xwords) {
+		zb := fftsqr(w.setup(m), z.Bits(), x.Bits())
+		return z.SetBits(zb)
+	}
+	return z.Mul(x, x)
+}
+
+// An arena allocates temporary buffers with a stack discipline:
+// buffers allocated after a call to mark are given back by
+// release, and are kept to be reused by later allocations.
+// Buffers are never freed: an arena holds the buffers needed
+// by the largest computations it served, until it is dropped.
+type arena struct {
+	words   pool
+	fermats pool
+	nats    pool
+	u64s    pool
+	cplxs   pool
+
+	wordBufs   []nat
+	fermatBufs [][]fermat
+	natBufs    [][]nat
+	u64Bufs    [][]uint64
+	cplxBufs   [][]complex128
+}
+
+// A pool keeps track of the buffers of an arena of a given type,
+// which are identified by their index in the arena.
+//
+// Buffers are sorted in size classes according to their capacity,
+// and a request for n elements is served by a free buffer of the
+// class of n or of the next class.
+type pool struct {
+	caps []int                    // capacity of each buffer.
+	used []int                    // buffers in use, in allocation order.
+	free [bits.UintSize + 1][]int // free buffers, by size class.
+}
+
+// An arenaMark records the state of an arena.
+type arenaMark struct {
+	words, fermats, nats, u64s, cplxs int
+}
+
+func sizeClass(n int) int {
+	return bits.Len(uint(n))
+}
+
+// get returns the index of a free buffer of at least n elements,
+// which is then in use, or false if there is none.
+func (p *pool) get(n int) (int, bool) {
+	c := sizeClass(n)
+	for ; c <= sizeClass(n)+1 && c < len(p.free); c++ {
+		free := p.free[c]
+		for i := len(free) - 1; i >= 0; i-- {
+			if b := free[i]; p.caps[b] >= n {
+				free[i] = free[len(free)-1]
+				p.free[c] = free[:len(free)-1]
+				p.used = append(p.used, b)
+				return b, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// add records a new buffer of n elements, which is in use,
+// and returns its index.
+func (p *pool) add(n int) int {
+	b := len(p.caps)
+	p.caps = append(p.caps, n)
+	p.used = append(p.used, b)
+	return b
+}
+
+// release makes the buffers in use since the first mark
+// buffers were allocated available for reuse.
+func (p *pool) release(mark int) {
+	for _, b := range p.used[mark:] {
+		c := sizeClass(p.caps[b])
+		p.free[c] = append(p.free[c], b)
+	}
+	p.used = p.used[:mark]
+}
+
+func (a *arena) mark() arenaMark {
+	return arenaMark{len(a.words.used), len(a.fermats.used), len(a.nats.used), len(a.u64s.used), len(a.cplxs.used)}
+}
+
+// release makes all buffers allocated since mark available
+// for reuse.
+func (a *arena) release(mark arenaMark) {
+	a.words.release(mark.words)
+	a.fermats.release(mark.fermats)
+	a.nats.release(mark.nats)
+	a.u64s.release(mark.u64s)
+	a.cplxs.release(mark.cplxs)
+}
+
+// nat returns a buffer of n words with unspecified contents.
+func (a *arena) nat(n int) nat {
+	b, ok := a.words.get(n)
+	if !ok {
+		b = a.words.add(n)
+		a.wordBufs = append(a.wordBufs, make(nat, n))
+	}
+	return a.wordBufs[b][:n]
+}
+
+// fermatSlice returns a slice of n fermat with unspecified contents.
+func (a *arena) fermatSlice(n int) []fermat {
+	b, ok := a.fermats.get(n)
+	if !ok {
+		b = a.fermats.add(n)
+		a.fermatBufs = append(a.fermatBufs, make([]fermat, n))
+	}
+	return a.fermatBufs[b][:n]
+}
+
+// natSlice returns a slice of n nat with unspecified contents.
+func (a *arena) natSlice(n int) []nat {
+	b, ok := a.nats.get(n)
+	if !ok {
+		b = a.nats.add(n)
+		a.natBufs = append(a.natBufs, make([]nat, n))
+	}
+	return a.natBufs[b][:n]
+}
+
+// uint64Slice returns a slice of n uint64 with unspecified contents.
+func (a *arena) uint64Slice(n int) []uint64 {
+	b, ok := a.u64s.get(n)
+	if !ok {
+		b = a.u64s.add(n)
+		a.u64Bufs = append(a.u64Bufs, make([]uint64, n))
+	}
+	return a.u64Bufs[b][:n]
+}
+
+// complexSlice returns a slice of n complex128 with unspecified contents.
+func (a *arena) complexSlice(n int) []complex128 {
+	b, ok := a.cplxs.get(n)
+	if !ok {
+		b = a.cplxs.add(n)
+		a.cplxBufs = append(a.cplxBufs, make([]complex128, n))
+	}
+	return a.cplxBufs[b][:n]
+}
@@ -0,0 +1,96 @@
+package bigfft
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	var ws Workspace
+	// Sizes are tried in an order that forces buffers
+	// to be reused for other size classes.
+	sizes := []int{2e6, 200e3, 1e3, 500e3, 2e6, 70e3}
+	var z Int
+	for _, size1 := range sizes {
+		for _, size2 := range sizes {
+			x := new(Int).SetBits(rndNat(size1 / _W))
+			y := new(Int).SetBits(rndNat(size2 / _W))
+			y.Neg(y)
+			want := new(Int).Mul(x, y)
+			if ws.MulTo(&z, x, y).Cmp(want) != 0 {
+				t.Errorf("incorrect product for sizes %d, %d", size1, size2)
+			}
+		}
+		x := new(Int).SetBits(rndNat(size1 / _W))
+		want := new(Int).Mul(x, x)
+		if ws.SqrTo(&z, x).Cmp(want) != 0 {
+			t.Errorf("incorrect square for size %d", size1)
+		}
+		// The result may alias the operand.
+		if ws.SqrTo(x, x).Cmp(want) != 0 {
+			t.Errorf("incorrect square for size %d when z aliases x", size1)
+		}
+	}
+}
+
+func TestWorkspaceAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation counts in short mode")
+	}
+	tests := []struct{ xsize, ysize int }{
+		{200e3, 200e3},
+		{1e6, 1e6},
+		{1e6, 70e3}, // unbalanced
+	}
+	for _, c := range []Config{{}, {NTTThreshold: 1 << 20}} {
+		ws := NewMultiplier(c).NewWorkspace()
+		for _, tt := range tests {
+			var x, y, z Int
+			x.SetBits(rndNat(tt.xsize / _W))
+			y.SetBits(rndNat(tt.ysize / _W))
+			ws.MulTo(&z, &x, &y) // allocate buffers.
+			if n := testing.AllocsPerRun(5, func() { ws.MulTo(&z, &x, &y) }); n != 0 {
+				t.Errorf("MulTo allocates %v times for sizes %d, %d, NTT threshold %d",
+					n, tt.xsize, tt.ysize, c.NTTThreshold)
+			}
+			ws.SqrTo(&z, &x)
+			if n := testing.AllocsPerRun(5, func() { ws.SqrTo(&z, &x) }); n != 0 {
+				t.Errorf("SqrTo allocates %v times for size %d, NTT threshold %d",
+					n, tt.xsize, c.NTTThreshold)
+			}
+		}
+	}
+}
+
+func TestWorkspacePool(t *testing.T) {
+	pool := sync.Pool{New: func() interface{} { return new(Workspace) }}
+	x := new(Int).SetBits(rndNat(300e3 / _W))
+	want := new(Int).Mul(x, x)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				ws := pool.Get().(*Workspace)
+				if ws.MulTo(new(Int), x, x).Cmp(want) != 0 {
+					t.Errorf("incorrect product with pooled workspace")
+				}
+				pool.Put(ws)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkWorkspaceMulTo_1Mb(b *testing.B) {
+	var x, y, z Int
+	x.SetBits(rndNat(1e6 / _W))
+	y.SetBits(rndNat(1e6 / _W))
+	var ws Workspace
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ws.MulTo(&z, &x, &y)
+	}
+}